@@ -1,19 +1,25 @@
-// Command dequeuer dequeues jobs and sends them to a downstream server.
+// Command dequeuer dequeues jobs and sends them to a downstream server, or
+// runs a local command for each of them.
 package main
 
 import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/config"
 	"github.com/Shyp/rickover/dequeuer"
+	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/workers"
+	"github.com/Shyp/rickover/server"
 	"github.com/Shyp/rickover/services"
 	"github.com/Shyp/rickover/setup"
 	"golang.org/x/sync/errgroup"
@@ -35,13 +41,37 @@ func main() {
 	err = setup.DB(db.DefaultConnection, dbConns)
 	checkError(err)
 
-	go setup.MeasureActiveQueries(1 * time.Second)
-	go setup.MeasureQueueDepth(5 * time.Second)
-	go setup.MeasureInProgressJobs(1 * time.Second)
-
+	// Only one dequeuer process at a time runs the sweepers and measures the
+	// queue; the rest wait to take over if the leader goes away.
+	leader := services.NewLeader()
+	leader.Go(func(ctx context.Context) {
+		setup.MeasureActiveQueriesContext(ctx, 1*time.Second)
+	})
+	leader.Go(func(ctx context.Context) {
+		setup.MeasureQueueDepthContext(ctx, 5*time.Second)
+	})
+	leader.Go(func(ctx context.Context) {
+		setup.MeasureInProgressJobsContext(ctx, 1*time.Second)
+	})
 	// Every minute, check for in-progress jobs that haven't been updated for
 	// 7 minutes, and mark them as failed.
-	go services.WatchStuckJobs(1*time.Minute, 7*time.Minute)
+	leader.Go(func(ctx context.Context) {
+		services.WatchStuckJobsContext(ctx, 1*time.Minute, 7*time.Minute)
+	})
+	// Archive jobs that expired while they were waiting in the queue.
+	leader.Go(func(ctx context.Context) {
+		services.WatchExpiredJobsContext(ctx, 30*time.Second)
+	})
+	// Unblock jobs whose parents were archived without unblocking them.
+	leader.Go(func(ctx context.Context) {
+		services.WatchBlockedJobsContext(ctx, 1*time.Minute)
+	})
+	// Enqueue jobs for any recurring schedules that are due.
+	leader.Go(func(ctx context.Context) {
+		services.WatchSchedulesContext(ctx, 5*time.Second)
+	})
+	leaderCtx, stopLeader := context.WithCancel(context.Background())
+	go leader.Run(leaderCtx)
 
 	// We're going to make a lot of requests to the same downstream service.
 	httpConns, err := config.GetInt("HTTP_MAX_IDLE_CONNS")
@@ -54,30 +84,106 @@ func main() {
 	metrics.Namespace = "rickover.dequeuer"
 	metrics.Start("worker")
 
-	downstreamPassword := os.Getenv("DOWNSTREAM_WORKER_AUTH")
-	if downstreamPassword == "" {
-		log.Printf("No DOWNSTREAM_WORKER_AUTH configured, setting an empty password for auth")
+	// Run only some job types in this process, so different job types can be
+	// deployed to different groups of machines.
+	concurrency, err := config.GetIntMap("DEQUEUER_CONCURRENCY")
+	checkError(err)
+	opts := dequeuer.PoolOptions{
+		Include:     config.GetStringSlice("DEQUEUER_INCLUDE_JOBS"),
+		Exclude:     config.GetStringSlice("DEQUEUER_EXCLUDE_JOBS"),
+		Concurrency: concurrency,
 	}
 
-	parsedUrl := config.GetURLOrBail("DOWNSTREAM_URL")
-	jp := services.NewJobProcessor(parsedUrl.String(), downstreamPassword)
+	// If any commands are configured, this process runs them instead of
+	// sending jobs to a downstream server.
+	commands, err := config.GetStringMap("DEQUEUER_COMMANDS")
+	checkError(err)
+	var w dequeuer.Worker
+	if len(commands) > 0 {
+		// Unless the job types are listed, only dequeue the ones that have a
+		// command.
+		includeCommands := len(opts.Include) == 0
+		cw := services.NewCommandWorker()
+		for name, path := range commands {
+			cw.Handle(name, services.Command{Path: path})
+			if includeCommands {
+				opts.Include = append(opts.Include, name)
+			}
+		}
+		w = cw
+	} else {
+		downstreamPassword := os.Getenv("DOWNSTREAM_WORKER_AUTH")
+		if downstreamPassword == "" {
+			log.Printf("No DOWNSTREAM_WORKER_AUTH configured, setting an empty password for auth")
+		}
+		parsedUrl := config.GetURLOrBail("DOWNSTREAM_URL")
+		w = services.NewJobProcessor(parsedUrl.String(), downstreamPassword)
+	}
+
+	// Register this process in the workers table before acquiring any jobs,
+	// so the jobs can be recorded against it.
+	hostname, err := os.Hostname()
+	checkError(err)
+	workerID, err := types.GenerateUUID(workers.Prefix)
+	checkError(err)
+	worker := &models.Worker{
+		ID:       workerID,
+		Hostname: hostname,
+		Pid:      os.Getpid(),
+		Version:  config.Version,
+	}
+	_, err = workers.Heartbeat(worker)
+	checkError(err)
+	opts.WorkerID = &worker.ID
 
 	// This creates a pool of dequeuers and starts them.
-	pools, err := dequeuer.CreatePools(jp, 200*time.Millisecond)
+	pools, err := dequeuer.CreatePoolsWithOptions(w, 200*time.Millisecond, opts)
+	checkError(err)
+	_, err = pools.Heartbeat(worker)
 	checkError(err)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	go pools.WatchHeartbeatContext(heartbeatCtx, worker, services.HeartbeatInterval)
+
+	// Optionally serve the admin API, for inspecting and adjusting the pools
+	// in this process.
+	if adminAddr := os.Getenv("ADMIN_ADDR"); adminAddr != "" {
+		adminPassword := os.Getenv("ADMIN_PASSWORD")
+		if adminPassword == "" {
+			log.Fatal("ADMIN_ADDR is set, but no ADMIN_PASSWORD is configured")
+		}
+		a := server.NewSharedSecretAuthorizer()
+		a.AddUser("admin", adminPassword)
+		go func() {
+			log.Printf("Admin server listening on %s\n", adminAddr)
+			log.Fatal(http.ListenAndServe(adminAddr, server.Admin(pools, w, a)))
+		}()
+	}
+
+	gracePeriod, err := config.GetDuration("SHUTDOWN_GRACE_PERIOD")
+	if err != nil {
+		gracePeriod = 10 * time.Second
+	}
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGTERM)
 	sig := <-sigterm
 	fmt.Printf("Caught signal %v, shutting down...\n", sig)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Give up leadership right away, so another process can take over.
+	stopLeader()
+	// Give in-flight jobs gracePeriod to finish. After that, jobs that the
+	// downstream server hasn't accepted are released back to the queue.
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
 	defer cancel()
 	g, _ := errgroup.WithContext(ctx)
 	for _, p := range pools {
 		if p != nil {
 			p := p
 			g.Go(func() error {
-				err = p.Shutdown()
+				err := p.ShutdownContext(ctx)
+				if err == context.DeadlineExceeded {
+					log.Printf("Grace period of %v elapsed, stopped pool %s", gracePeriod, p.Name)
+					return nil
+				}
 				if err != nil {
 					log.Printf("Error shutting down pool: %s\n", err.Error())
 				}
@@ -88,5 +194,9 @@ func main() {
 	if err := g.Wait(); err != nil {
 		log.Fatal(err)
 	}
+	stopHeartbeat()
+	if err := workers.Delete(worker.ID); err != nil {
+		log.Printf("Error deregistering worker %s: %s\n", worker.ID.String(), err.Error())
+	}
 	fmt.Println("All pools shut down. Quitting.")
 }
@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const Version = "1.1"
@@ -19,6 +21,61 @@ func GetInt(varName string) (int, error) {
 	return strconv.Atoi(envVar)
 }
 
+// GetDuration loads the environment variable varName, parses it as a
+// duration like "30s" or "2m", and returns that duration or an error.
+func GetDuration(varName string) (time.Duration, error) {
+	envVar := os.Getenv(varName)
+	return time.ParseDuration(envVar)
+}
+
+// GetStringSlice loads the environment variable varName, and splits it on
+// commas. Whitespace around each value is trimmed, and empty values are
+// dropped, so an unset variable returns an empty slice.
+func GetStringSlice(varName string) []string {
+	vals := make([]string, 0)
+	for _, val := range strings.Split(os.Getenv(varName), ",") {
+		val = strings.TrimSpace(val)
+		if val != "" {
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
+// GetStringMap loads the environment variable varName, a comma separated
+// list of "key=value" pairs like "rotate-logs=/usr/local/bin/rotate-logs", and
+// returns a map of each key to its value.
+func GetStringMap(varName string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range GetStringSlice(varName) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid value for %s: %q should look like key=value", varName, pair)
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m, nil
+}
+
+// GetIntMap loads the environment variable varName, a comma separated list
+// of "key=value" pairs like "render-pdf=2,send-email=20", and returns a map of
+// each key to its integer value.
+func GetIntMap(varName string) (map[string]int, error) {
+	sm, err := GetStringMap(varName)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int)
+	for key, val := range sm {
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for %s: %s", varName, err.Error())
+		}
+		m[key] = i
+	}
+	return m, nil
+}
+
 func GetURLOrBail(urlEnvVar string) *url.URL {
 	downstreamUrl := os.Getenv(urlEnvVar)
 	if downstreamUrl == "" {
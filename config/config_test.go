@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/Shyp/rickover/test"
 )
@@ -26,6 +27,21 @@ func TestGetInt(t *testing.T) {
 	test.AssertEquals(t, i, 5)
 }
 
+func TestGetDuration(t *testing.T) {
+	err := os.Setenv("CONFIG_TEST_DURATION_VAR", "45s")
+	test.AssertNotError(t, err, "setting env var")
+	defer func() {
+		os.Unsetenv("CONFIG_TEST_DURATION_VAR")
+	}()
+	d, err := GetDuration("CONFIG_TEST_DURATION_VAR")
+	test.AssertNotError(t, err, "getting env var")
+	test.AssertEquals(t, d, 45*time.Second)
+
+	os.Setenv("CONFIG_TEST_DURATION_VAR", "45")
+	_, err = GetDuration("CONFIG_TEST_DURATION_VAR")
+	test.AssertError(t, err, "")
+}
+
 func TestGetIntError(t *testing.T) {
 	err := os.Setenv("CONFIG_TEST_INT_VAR", "bad")
 	test.AssertNotError(t, err, "setting env var")
@@ -35,3 +51,38 @@ func TestGetIntError(t *testing.T) {
 	_, err = GetInt("CONFIG_TEST_INT_VAR")
 	test.AssertError(t, err, "getting bad env var")
 }
+
+func TestGetStringSlice(t *testing.T) {
+	defer os.Unsetenv("CONFIG_TEST_SLICE_VAR")
+	test.AssertDeepEquals(t, GetStringSlice("CONFIG_TEST_SLICE_VAR"), []string{})
+	os.Setenv("CONFIG_TEST_SLICE_VAR", "render-pdf, send-email,,")
+	test.AssertDeepEquals(t, GetStringSlice("CONFIG_TEST_SLICE_VAR"), []string{"render-pdf", "send-email"})
+}
+
+func TestGetStringMap(t *testing.T) {
+	defer os.Unsetenv("CONFIG_TEST_MAP_VAR")
+	os.Setenv("CONFIG_TEST_MAP_VAR", "rotate-logs=/usr/local/bin/rotate-logs, send-email = mail.sh")
+	m, err := GetStringMap("CONFIG_TEST_MAP_VAR")
+	test.AssertNotError(t, err, "getting env var")
+	test.AssertDeepEquals(t, m, map[string]string{"rotate-logs": "/usr/local/bin/rotate-logs", "send-email": "mail.sh"})
+
+	os.Setenv("CONFIG_TEST_MAP_VAR", "rotate-logs")
+	_, err = GetStringMap("CONFIG_TEST_MAP_VAR")
+	test.AssertError(t, err, "")
+}
+
+func TestGetIntMap(t *testing.T) {
+	defer os.Unsetenv("CONFIG_TEST_MAP_VAR")
+	os.Setenv("CONFIG_TEST_MAP_VAR", "render-pdf=2, send-email = 20")
+	m, err := GetIntMap("CONFIG_TEST_MAP_VAR")
+	test.AssertNotError(t, err, "getting env var")
+	test.AssertDeepEquals(t, m, map[string]int{"render-pdf": 2, "send-email": 20})
+
+	os.Setenv("CONFIG_TEST_MAP_VAR", "render-pdf")
+	_, err = GetIntMap("CONFIG_TEST_MAP_VAR")
+	test.AssertError(t, err, "")
+
+	os.Setenv("CONFIG_TEST_MAP_VAR", "render-pdf=two")
+	_, err = GetIntMap("CONFIG_TEST_MAP_VAR")
+	test.AssertError(t, err, "")
+}
@@ -0,0 +1,229 @@
+// Package cron parses standard five-field cron expressions and finds the next
+// time they should run.
+//
+// Fields are minute, hour, day of month, month and day of week. Each field
+// accepts "*", single values, ranges ("1-5"), steps ("*/15", "0-30/10") and
+// comma separated lists of those. Months and days of the week can be given by
+// their three letter English names ("JAN", "mon"). Day of week 0 and 7 are
+// both Sunday. The shortcuts @yearly, @annually, @monthly, @weekly, @daily,
+// @midnight and @hourly are also supported.
+//
+// As with Vixie cron, if both the day of month and day of week fields are
+// restricted, a time matches if either field matches.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule is a parsed cron expression.
+type Schedule struct {
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+
+	// domStar and dowStar record whether the day fields were "*", which
+	// changes how they combine.
+	domStar bool
+	dowStar bool
+}
+
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse parses a cron expression, returning an error if it's invalid.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shortcuts[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(parts), expr)
+	}
+	s := new(Schedule)
+	if err := parseField(parts[0], 0, 59, nil, s.minute[:]); err != nil {
+		return nil, err
+	}
+	if err := parseField(parts[1], 0, 23, nil, s.hour[:]); err != nil {
+		return nil, err
+	}
+	if err := parseField(parts[2], 1, 31, nil, s.dom[:]); err != nil {
+		return nil, err
+	}
+	if err := parseField(parts[3], 1, 12, monthNames, s.month[:]); err != nil {
+		return nil, err
+	}
+	// Allow 7 for Sunday, then fold it into 0.
+	var dow [8]bool
+	if err := parseField(parts[4], 0, 7, dayNames, dow[:]); err != nil {
+		return nil, err
+	}
+	copy(s.dow[:], dow[:7])
+	if dow[7] {
+		s.dow[0] = true
+	}
+	s.domStar = parts[2] == "*" || parts[2] == "?"
+	s.dowStar = parts[4] == "*" || parts[4] == "?"
+	return s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps, and
+// marks the matching entries in bits.
+func parseField(field string, min int, max int, names map[string]int, bits []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := part, 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			hasStep = true
+			rng = part[:i]
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return fmt.Errorf("cron: invalid step in %q", part)
+			}
+		}
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = min, max
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = parseValue(rng[:i], names); err != nil {
+				return err
+			}
+			if hi, err = parseValue(rng[i+1:], names); err != nil {
+				return err
+			}
+		default:
+			var err error
+			if lo, err = parseValue(rng, names); err != nil {
+				return err
+			}
+			hi = lo
+			// "5/15" means every 15, starting at 5.
+			if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("cron: %q is out of range (%d-%d)", part, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits[i] = true
+		}
+	}
+	return nil
+}
+
+func parseValue(s string, names map[string]int) (int, error) {
+	if names != nil {
+		if v, ok := names[strings.ToLower(s)]; ok {
+			return v, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q", s)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's
+// location. Returns the zero time if there's no match in the next five years,
+// which can happen for expressions like "0 0 30 2 *".
+//
+// When clocks go forward, times that don't exist that day are skipped. When
+// clocks go back, times that happen twice only match the first time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// Start at the beginning of the next minute.
+	next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	if !next.After(t) {
+		// Around a daylight saving change; step forward in absolute time.
+		next = t.Truncate(time.Minute).Add(time.Minute)
+	}
+	yearLimit := next.Year() + 5
+
+wrap:
+	if next.Year() > yearLimit {
+		return time.Time{}
+	}
+	for !s.month[next.Month()] {
+		next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, loc)
+		if next.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(next) {
+		day := next.Day()
+		next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, loc)
+		for next.Day() == day {
+			// Midnight doesn't exist because clocks went forward.
+			next = next.Add(time.Hour)
+		}
+		if next.Day() == 1 {
+			goto wrap
+		}
+	}
+	for !s.hour[next.Hour()] {
+		day := next.Day()
+		prev := next
+		next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, loc)
+		if !next.After(prev) {
+			// time.Date normalizes times that don't exist because clocks
+			// went forward to an earlier time, so step over the gap.
+			next = prev.Add(time.Duration(60-prev.Minute()) * time.Minute)
+		}
+		if next.Day() != day {
+			goto wrap
+		}
+	}
+	for !s.minute[next.Minute()] {
+		hour := next.Hour()
+		prev := next
+		next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute()+1, 0, 0, loc)
+		if !next.After(prev) {
+			next = prev.Add(time.Minute)
+		}
+		if next.Hour() != hour {
+			goto wrap
+		}
+	}
+	if !next.After(t) {
+		// Only possible in the repeated hour after clocks go back; skip ahead
+		// past it.
+		return s.Next(t.Add(time.Hour))
+	}
+	return next
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[t.Weekday()]
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,109 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/test"
+)
+
+func mustParse(t *testing.T, expr string) *Schedule {
+	t.Helper()
+	s, err := Parse(expr)
+	test.AssertNotError(t, err, expr)
+	return s
+}
+
+var nextTests = []struct {
+	expr string
+	from string
+	next string
+}{
+	{"* * * * *", "2016-01-01T00:00:00Z", "2016-01-01T00:01:00Z"},
+	{"* * * * *", "2016-01-01T00:00:30Z", "2016-01-01T00:01:00Z"},
+	{"*/15 * * * *", "2016-01-01T00:16:00Z", "2016-01-01T00:30:00Z"},
+	{"0 * * * *", "2016-01-01T23:00:00Z", "2016-01-02T00:00:00Z"},
+	{"30 9 * * mon-fri", "2016-01-01T10:00:00Z", "2016-01-04T09:30:00Z"},
+	{"0 0 1 jan *", "2016-06-01T00:00:00Z", "2017-01-01T00:00:00Z"},
+	{"0 0 29 2 *", "2016-03-01T00:00:00Z", "2020-02-29T00:00:00Z"},
+	{"0 12 * * 7", "2016-01-01T00:00:00Z", "2016-01-03T12:00:00Z"},
+	{"5/20 * * * *", "2016-01-01T00:06:00Z", "2016-01-01T00:25:00Z"},
+	{"0 0,12 * * *", "2016-01-01T01:00:00Z", "2016-01-01T12:00:00Z"},
+	// Either day field can match when both are restricted.
+	{"0 0 15 * mon", "2016-01-01T00:00:00Z", "2016-01-04T00:00:00Z"},
+	{"@daily", "2016-01-01T00:00:00Z", "2016-01-02T00:00:00Z"},
+	{"@hourly", "2016-01-01T00:59:59Z", "2016-01-01T01:00:00Z"},
+}
+
+func TestNext(t *testing.T) {
+	t.Parallel()
+	for _, tt := range nextTests {
+		s := mustParse(t, tt.expr)
+		from, _ := time.Parse(time.RFC3339, tt.from)
+		want, _ := time.Parse(time.RFC3339, tt.next)
+		got := s.Next(from)
+		if !got.Equal(want) {
+			t.Errorf("Next(%q, %s): got %s, want %s", tt.expr, tt.from, got, want)
+		}
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	t.Parallel()
+	s := mustParse(t, "0 0 30 2 *")
+	test.AssertEquals(t, s.Next(time.Now()).IsZero(), true)
+}
+
+func TestNextUsesLocation(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("no time zone database available")
+	}
+	s := mustParse(t, "0 9 * * *")
+	from := time.Date(2016, 1, 1, 10, 0, 0, 0, loc)
+	test.AssertEquals(t, s.Next(from), time.Date(2016, 1, 2, 9, 0, 0, 0, loc))
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("no time zone database available")
+	}
+	// 2:30am doesn't exist on March 13, 2016.
+	s := mustParse(t, "30 2 * * *")
+	from := time.Date(2016, 3, 13, 0, 0, 0, 0, loc)
+	test.AssertEquals(t, s.Next(from), time.Date(2016, 3, 14, 2, 30, 0, 0, loc))
+
+	// 1:30am happens twice on November 6, 2016; only run it once.
+	s = mustParse(t, "30 1 * * *")
+	from = time.Date(2016, 11, 6, 0, 0, 0, 0, loc)
+	first := s.Next(from)
+	test.AssertEquals(t, first, time.Date(2016, 11, 6, 1, 30, 0, 0, loc))
+	second := s.Next(first)
+	test.AssertEquals(t, second, time.Date(2016, 11, 7, 1, 30, 0, 0, loc))
+}
+
+var invalidTests = []string{
+	"",
+	"* * * *",
+	"60 * * * *",
+	"* 24 * * *",
+	"* * 0 * *",
+	"* * * 13 *",
+	"* * * * 8",
+	"*/0 * * * *",
+	"5-1 * * * *",
+	"foo * * * *",
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+	for _, expr := range invalidTests {
+		_, err := Parse(expr)
+		if err == nil {
+			t.Errorf("Parse(%q): expected an error, got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE queued_jobs ADD COLUMN debounce_key TEXT;
+CREATE UNIQUE INDEX queued_jobs_debounce_key ON queued_jobs(name, debounce_key) WHERE status='queued';
+
+-- +goose Down
+DROP INDEX queued_jobs_debounce_key;
+ALTER TABLE queued_jobs DROP COLUMN debounce_key;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE schedules (
+	name TEXT PRIMARY KEY,
+	job_name TEXT NOT NULL REFERENCES jobs(name),
+	cron TEXT NOT NULL,
+	time_zone TEXT NOT NULL DEFAULT 'UTC',
+	data_template TEXT NOT NULL,
+	next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	max_catch_up INTEGER NOT NULL DEFAULT 1 CHECK (max_catch_up > 0),
+	last_error TEXT,
+	last_error_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX schedules_next_run_at ON schedules(next_run_at);
+
+-- +goose Down
+DROP TABLE schedules;
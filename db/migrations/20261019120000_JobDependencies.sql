@@ -0,0 +1,29 @@
+-- +goose Up
+-- ALTER TYPE ... ADD VALUE can't run inside a transaction, so recreate the
+-- type instead. The partial indexes compare against the old type, so they
+-- need to be rebuilt.
+ALTER TYPE job_status RENAME TO job_status_old;
+CREATE TYPE job_status AS enum('queued', 'in-progress', 'blocked');
+DROP INDEX find_queued_job;
+DROP INDEX queued_jobs_debounce_key;
+ALTER TABLE queued_jobs ALTER COLUMN status TYPE job_status USING status::text::job_status;
+DROP TYPE job_status_old;
+CREATE INDEX find_queued_job ON queued_jobs(name, run_after) WHERE status='queued';
+CREATE UNIQUE INDEX queued_jobs_debounce_key ON queued_jobs(name, debounce_key) WHERE status='queued';
+
+ALTER TABLE queued_jobs ADD COLUMN depends_on UUID[] NOT NULL DEFAULT '{}';
+CREATE INDEX queued_jobs_depends_on ON queued_jobs USING GIN (depends_on) WHERE status='blocked';
+
+-- +goose Down
+DROP INDEX queued_jobs_depends_on;
+ALTER TABLE queued_jobs DROP COLUMN depends_on;
+DELETE FROM queued_jobs WHERE status='blocked';
+
+ALTER TYPE job_status RENAME TO job_status_old;
+CREATE TYPE job_status AS enum('queued', 'in-progress');
+DROP INDEX find_queued_job;
+DROP INDEX queued_jobs_debounce_key;
+ALTER TABLE queued_jobs ALTER COLUMN status TYPE job_status USING status::text::job_status;
+DROP TYPE job_status_old;
+CREATE INDEX find_queued_job ON queued_jobs(name, run_after) WHERE status='queued';
+CREATE UNIQUE INDEX queued_jobs_debounce_key ON queued_jobs(name, debounce_key) WHERE status='queued';
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN on_success TEXT REFERENCES jobs(name);
+ALTER TABLE jobs ADD COLUMN on_failure TEXT REFERENCES jobs(name);
+ALTER TABLE queued_jobs ADD COLUMN parent_id UUID;
+ALTER TABLE archived_jobs ADD COLUMN parent_id UUID;
+
+-- +goose Down
+ALTER TABLE archived_jobs DROP COLUMN parent_id;
+ALTER TABLE queued_jobs DROP COLUMN parent_id;
+ALTER TABLE jobs DROP COLUMN on_failure;
+ALTER TABLE jobs DROP COLUMN on_success;
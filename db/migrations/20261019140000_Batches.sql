@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE batches (
+	id UUID PRIMARY KEY,
+	on_complete TEXT REFERENCES jobs(name),
+	data JSONB NOT NULL DEFAULT '{}',
+	closed_at TIMESTAMP WITH TIME ZONE,
+	completed_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+ALTER TABLE queued_jobs ADD COLUMN batch_id UUID REFERENCES batches(id);
+ALTER TABLE archived_jobs ADD COLUMN batch_id UUID;
+CREATE INDEX queued_jobs_batch_id ON queued_jobs(batch_id) WHERE batch_id IS NOT NULL;
+CREATE INDEX archived_jobs_batch_id ON archived_jobs(batch_id) WHERE batch_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX archived_jobs_batch_id;
+DROP INDEX queued_jobs_batch_id;
+ALTER TABLE archived_jobs DROP COLUMN batch_id;
+ALTER TABLE queued_jobs DROP COLUMN batch_id;
+DROP TABLE batches;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE queued_jobs ADD COLUMN concurrency_key TEXT;
+ALTER TABLE queued_jobs ADD COLUMN concurrency_limit SMALLINT NOT NULL DEFAULT 1 CHECK (concurrency_limit > 0);
+CREATE INDEX queued_jobs_concurrency_key ON queued_jobs(concurrency_key) WHERE status = 'in-progress';
+
+-- +goose Down
+DROP INDEX queued_jobs_concurrency_key;
+ALTER TABLE queued_jobs DROP COLUMN concurrency_limit;
+ALTER TABLE queued_jobs DROP COLUMN concurrency_key;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE queued_jobs ADD COLUMN ordering_key TEXT;
+CREATE INDEX queued_jobs_ordering_key ON queued_jobs(ordering_key, created_at) WHERE ordering_key IS NOT NULL;
+
+-- +goose Down
+DROP INDEX queued_jobs_ordering_key;
+ALTER TABLE queued_jobs DROP COLUMN ordering_key;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN paused BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE jobs ADD COLUMN paused_until TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE jobs DROP COLUMN paused_until;
+ALTER TABLE jobs DROP COLUMN paused;
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN rate_limit INTEGER NOT NULL DEFAULT 0 CHECK (rate_limit >= 0);
+ALTER TABLE jobs ADD COLUMN rate_interval_ms INTEGER NOT NULL DEFAULT 1000 CHECK (rate_interval_ms > 0);
+ALTER TABLE jobs ADD COLUMN rate_burst INTEGER NOT NULL DEFAULT 0 CHECK (rate_burst >= 0);
+CREATE TABLE rate_limits (
+	name TEXT PRIMARY KEY REFERENCES jobs(name),
+	tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+INSERT INTO rate_limits (name) SELECT name FROM jobs;
+
+-- +goose Down
+DROP TABLE rate_limits;
+ALTER TABLE jobs DROP COLUMN rate_burst;
+ALTER TABLE jobs DROP COLUMN rate_interval_ms;
+ALTER TABLE jobs DROP COLUMN rate_limit;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TYPE circuit_state AS enum('closed', 'open', 'half_open');
+CREATE TABLE circuit_breakers (
+	name TEXT PRIMARY KEY REFERENCES jobs(name),
+	state circuit_state NOT NULL DEFAULT 'closed',
+	failures INTEGER NOT NULL DEFAULT 0 CHECK (failures >= 0),
+	open_until TIMESTAMP WITH TIME ZONE,
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE circuit_breakers;
+DROP TYPE circuit_state;
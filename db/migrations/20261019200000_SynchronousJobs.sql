@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN synchronous BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE jobs DROP COLUMN synchronous;
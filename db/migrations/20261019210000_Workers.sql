@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE workers (
+	id UUID PRIMARY KEY,
+	hostname TEXT NOT NULL,
+	pid INTEGER NOT NULL,
+	version TEXT NOT NULL,
+	job_types TEXT[] NOT NULL DEFAULT '{}',
+	concurrency INTEGER NOT NULL DEFAULT 0 CHECK (concurrency >= 0),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX workers_heartbeat_at ON workers (heartbeat_at);
+ALTER TABLE queued_jobs ADD COLUMN worker_id UUID REFERENCES workers(id) ON DELETE SET NULL;
+CREATE INDEX queued_jobs_worker_id ON queued_jobs (worker_id) WHERE worker_id IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE queued_jobs DROP COLUMN worker_id;
+DROP TABLE workers;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN min_concurrency SMALLINT NOT NULL DEFAULT 0 CHECK (min_concurrency >= 0);
+ALTER TABLE jobs ADD COLUMN max_concurrency SMALLINT NOT NULL DEFAULT 0 CHECK (max_concurrency >= 0);
+ALTER TABLE jobs ADD CONSTRAINT jobs_autoscale_check CHECK (
+	max_concurrency = 0 OR (min_concurrency <= concurrency AND concurrency <= max_concurrency)
+);
+
+-- +goose Down
+ALTER TABLE jobs DROP CONSTRAINT jobs_autoscale_check;
+ALTER TABLE jobs DROP COLUMN max_concurrency;
+ALTER TABLE jobs DROP COLUMN min_concurrency;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN on_expire TEXT REFERENCES jobs(name);
+CREATE INDEX queued_jobs_expires_at ON queued_jobs (expires_at) WHERE expires_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX queued_jobs_expires_at;
+ALTER TABLE jobs DROP COLUMN on_expire;
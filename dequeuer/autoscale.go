@@ -0,0 +1,165 @@
+package dequeuer
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+)
+
+// AutoscaleInterval is how often autoscaled pools are resized.
+var AutoscaleInterval = 10 * time.Second
+
+// AutoscaleErrorRate is the fraction of downstream requests that can fail
+// before an autoscaled pool backs off. See services.DownstreamStats for the
+// errors that count.
+var AutoscaleErrorRate = 0.1
+
+// AutoscaleMinRequests is the number of downstream requests needed in an
+// interval before the error rate and latency are taken into account.
+const AutoscaleMinRequests = 5
+
+// autoscaleInput is what a pool knows when it decides how many dequeuers to
+// run.
+type autoscaleInput struct {
+	current, min, max int
+	// ready is the number of jobs that are ready to run.
+	ready int
+	// jobLatency is how long the pool's dequeuers spent on each job, on
+	// average, in the last interval.
+	jobLatency time.Duration
+	interval   time.Duration
+	downstream services.DownstreamStats
+	// baseline is the lowest mean downstream latency seen so far.
+	baseline time.Duration
+}
+
+// desiredDequeuers returns the number of dequeuers a pool should run, and a
+// short reason for the change.
+//
+// If too many downstream requests fail, the pool halves. If the downstream
+// server has slowed to more than twice its usual latency, the pool doesn't
+// grow. Otherwise the pool grows to the number of dequeuers needed to finish
+// the ready jobs within one interval, up to double its current size, or
+// shrinks by one if it has more than it needs.
+func desiredDequeuers(in autoscaleInput) (int, string) {
+	clamp := func(n int) int {
+		if n < in.min {
+			return in.min
+		}
+		if n > in.max {
+			return in.max
+		}
+		return n
+	}
+	enoughRequests := in.downstream.Requests >= AutoscaleMinRequests
+	if enoughRequests && in.downstream.ErrorRate() >= AutoscaleErrorRate {
+		return clamp(in.current / 2), "backoff"
+	}
+	needed := in.ready
+	if in.jobLatency > 0 && in.interval > 0 {
+		// Each dequeuer can finish interval/jobLatency jobs per interval.
+		needed = int((int64(in.ready)*int64(in.jobLatency) + int64(in.interval) - 1) / int64(in.interval))
+	}
+	if needed > in.current {
+		if enoughRequests && in.baseline > 0 && in.downstream.MeanLatency() > 2*in.baseline {
+			return clamp(in.current), "slow"
+		}
+		limit := 2 * in.current
+		if limit == 0 {
+			limit = 1
+		}
+		if needed > limit {
+			needed = limit
+		}
+		return clamp(needed), "up"
+	}
+	if needed < in.current {
+		return clamp(in.current - 1), "down"
+	}
+	return clamp(in.current), "hold"
+}
+
+// Autoscale adds and removes dequeuers every interval, keeping between min
+// and max of them, based on the number of ready jobs, how long jobs take, and
+// the error rate and latency of requests to the downstream server. New
+// dequeuers do their work with w. Autoscale returns once the pool starts
+// shutting down, and the pool doesn't remove its dequeuers until it has.
+func (p *Pool) Autoscale(w Worker, min, max int, interval time.Duration) {
+	p.mu.Lock()
+	if p.receivedShutdownSignal || p.stopAutoscale != nil {
+		p.mu.Unlock()
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	p.stopAutoscale, p.autoscaleDone = stop, done
+	p.mu.Unlock()
+	defer close(done)
+
+	var baseline time.Duration
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ready, err := queued_jobs.CountReady(p.Name)
+		if err != nil {
+			log.Printf("autoscale: Error counting ready %s jobs: %s", p.Name, err)
+			continue
+		}
+		stats := services.TakeDownstreamStats(p.Name)
+		if stats.Requests >= AutoscaleMinRequests {
+			if latency := stats.MeanLatency(); baseline == 0 || latency < baseline {
+				baseline = latency
+			}
+		}
+		current := p.Status().Dequeuers
+		want, reason := desiredDequeuers(autoscaleInput{
+			current:    current,
+			min:        min,
+			max:        max,
+			ready:      ready,
+			jobLatency: p.takeJobLatency(),
+			interval:   interval,
+			downstream: stats,
+			baseline:   baseline,
+		})
+		go metrics.Measure(fmt.Sprintf("autoscale.%s.dequeuers", p.Name), int64(want))
+		if want == current {
+			continue
+		}
+		log.Printf("autoscale: Changing %s dequeuers from %d to %d (%s)", p.Name, current, want, reason)
+		go metrics.Increment(fmt.Sprintf("autoscale.%s.%s", p.Name, reason))
+		for i := current; i < want; i++ {
+			if err := p.AddDequeuer(w); err != nil {
+				return
+			}
+		}
+		for i := want; i < current; i++ {
+			if err := p.RemoveDequeuer(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// stopAutoscaling stops the pool's autoscaler, if it has one, and waits for
+// it to return, so it can't add or remove dequeuers during a shutdown. The
+// caller must have set receivedShutdownSignal, so it isn't started again.
+func (p *Pool) stopAutoscaling() {
+	p.mu.Lock()
+	stop, done := p.stopAutoscale, p.autoscaleDone
+	p.stopAutoscale, p.autoscaleDone = nil, nil
+	p.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
@@ -2,18 +2,24 @@
 package dequeuer
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"runtime/debug"
 	"sync"
 	"time"
 
 	"github.com/Shyp/go-dberror"
 	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -38,10 +44,41 @@ func (ps Pools) NumDequeuers() int {
 	return dequeuerCount
 }
 
+// PoolOptions select which job types a dequeuer process works on, and how
+// many dequeuers it runs for each. Use them to run separate groups of
+// dequeuer processes for different job types.
+type PoolOptions struct {
+	// Include is the list of job types to create pools for. If empty, pools
+	// are created for every job type in the database.
+	Include []string
+
+	// Exclude is a list of job types to skip, even if they are in Include.
+	Exclude []string
+
+	// Concurrency overrides the number of dequeuers to run for a job type
+	// in this process. Job types that aren't in the map use the Concurrency
+	// in the jobs table.
+	Concurrency map[string]int
+
+	// WorkerID is recorded on each job the pools acquire, if set. See
+	// Pools.Heartbeat.
+	WorkerID *types.PrefixUUID
+}
+
 // CreatePools creates job pools for all jobs in the database. The provided
 // Worker w will be shared between all dequeuers, so it must be thread safe.
 func CreatePools(w Worker, maxInitialJitter time.Duration) (Pools, error) {
-	jobs, err := jobs.GetAll()
+	return CreatePoolsWithOptions(w, maxInitialJitter, PoolOptions{})
+}
+
+// CreatePoolsWithOptions is like CreatePools, but only creates pools for the
+// job types selected by opts.
+func CreatePoolsWithOptions(w Worker, maxInitialJitter time.Duration, opts PoolOptions) (Pools, error) {
+	allJobs, err := jobs.GetAll()
+	if err != nil {
+		return Pools{}, err
+	}
+	jobs, err := selectJobs(allJobs, opts)
 	if err != nil {
 		return Pools{}, err
 	}
@@ -53,11 +90,22 @@ func CreatePools(w Worker, maxInitialJitter time.Duration) (Pools, error) {
 		// counter iterates
 		i := i
 		name := job.Name
-		concurrency := job.Concurrency
+		rateLimited := job.RateLimit > 0
+		concurrency := int(job.Concurrency)
+		// Autoscale between the job type's min and max concurrency, unless
+		// this process sets its own concurrency for the job type.
+		autoscale := job.MaxConcurrency > 0
+		min, max := int(job.MinConcurrency), int(job.MaxConcurrency)
+		if c, ok := opts.Concurrency[name]; ok {
+			concurrency = c
+			autoscale = false
+		}
 		g.Go(func() error {
 			p := NewPool(name)
+			p.WorkerID = opts.WorkerID
+			p.RateLimited = rateLimited
 			var innerg errgroup.Group
-			for j := uint8(0); j < concurrency; j++ {
+			for j := 0; j < concurrency; j++ {
 				innerg.Go(func() error {
 					time.Sleep(time.Duration(rand.Float64()) * maxInitialJitter)
 					err := p.AddDequeuer(w)
@@ -70,6 +118,9 @@ func CreatePools(w Worker, maxInitialJitter time.Duration) (Pools, error) {
 			if err := innerg.Wait(); err != nil {
 				return err
 			}
+			if autoscale {
+				go p.Autoscale(w, min, max, AutoscaleInterval)
+			}
 			pools[i] = p
 			return nil
 		})
@@ -80,6 +131,50 @@ func CreatePools(w Worker, maxInitialJitter time.Duration) (Pools, error) {
 	return pools, nil
 }
 
+// selectJobs returns the job types in allJobs that are selected by opts.
+// Job types named in opts that don't exist are logged, since they're
+// probably typos.
+func selectJobs(allJobs []*models.Job, opts PoolOptions) ([]*models.Job, error) {
+	for name, c := range opts.Concurrency {
+		if c < 0 {
+			return nil, fmt.Errorf("Invalid concurrency for job type %s: %d", name, c)
+		}
+	}
+	known := make(map[string]bool, len(allJobs))
+	for _, job := range allJobs {
+		known[job.Name] = true
+	}
+	names := append(append([]string{}, opts.Include...), opts.Exclude...)
+	for name := range opts.Concurrency {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if !known[name] {
+			log.Printf("Unknown job type %s in dequeuer options, ignoring", name)
+		}
+	}
+
+	included := make(map[string]bool, len(opts.Include))
+	for _, name := range opts.Include {
+		included[name] = true
+	}
+	excluded := make(map[string]bool, len(opts.Exclude))
+	for _, name := range opts.Exclude {
+		excluded[name] = true
+	}
+	selected := make([]*models.Job, 0, len(allJobs))
+	for _, job := range allJobs {
+		if len(included) > 0 && !included[job.Name] {
+			continue
+		}
+		if excluded[job.Name] {
+			continue
+		}
+		selected = append(selected, job)
+	}
+	return selected, nil
+}
+
 // A Pool contains an array of dequeuers, all of which perform work for the
 // same models.Job.
 type Pool struct {
@@ -88,12 +183,53 @@ type Pool struct {
 	receivedShutdownSignal bool
 	mu                     sync.Mutex
 	wg                     sync.WaitGroup
+
+	// WorkerID is recorded on each job the pool's dequeuers acquire, if set.
+	// Set it before adding dequeuers.
+	WorkerID *types.PrefixUUID
+
+	// RateLimited is set if the job type has a rate limit. Dequeuers take a
+	// token from the job type's bucket before acquiring each job. Set it
+	// before adding dequeuers.
+	RateLimited bool
+
+	// nextID is the ID of the last dequeuer added to the pool.
+	nextID int
+
+	// stopAutoscale is closed to stop the pool's autoscaler, which closes
+	// autoscaleDone once it has returned. Both are nil if the pool isn't
+	// autoscaling.
+	stopAutoscale chan struct{}
+	autoscaleDone chan struct{}
+
+	// statusMu protects the fields below, which are reported by Status.
+	statusMu       sync.Mutex
+	paused         bool
+	lastAcquireErr *AcquireError
+	// jobCount and jobTime are the number of jobs the pool has finished, and
+	// the time spent on them, since takeJobLatency was last called.
+	jobCount int64
+	jobTime  time.Duration
 }
 
 type Dequeuer struct {
 	ID       int
 	QuitChan chan bool
 	W        Worker
+
+	// ctx is passed to ContextWorkers, and cancelled if the pool is shut
+	// down before in-flight work finishes.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// pool is the Pool the dequeuer belongs to, if it was started by
+	// Pool.AddDequeuer.
+	pool *Pool
+
+	// mu protects job and started, the job the dequeuer is working on.
+	mu      sync.Mutex
+	job     *models.QueuedJob
+	started time.Time
 }
 
 // A Worker does some work with a QueuedJob. Worker implementations may be
@@ -119,18 +255,41 @@ type Worker interface {
 	Sleep(failedAttempts uint32) time.Duration
 }
 
+// A ContextWorker is a Worker that can stop work on a job when ctx is
+// cancelled, for example because the dequeuer is shutting down. Dequeuers
+// call DoWorkContext instead of DoWork for Workers that implement it.
+type ContextWorker interface {
+	Worker
+
+	// DoWorkContext is like DoWork, but should return promptly once ctx is
+	// cancelled.
+	DoWorkContext(ctx context.Context, qj *models.QueuedJob) error
+}
+
+func doWork(ctx context.Context, w Worker, qj *models.QueuedJob) error {
+	if cw, ok := w.(ContextWorker); ok {
+		return cw.DoWorkContext(ctx, qj)
+	}
+	return w.DoWork(qj)
+}
+
 // AddDequeuer adds a Dequeuer to the Pool. w should be the work that the
 // Dequeuer will do with a dequeued job.
 func (p *Pool) AddDequeuer(w Worker) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.receivedShutdownSignal {
 		return poolShutdown
 	}
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.nextID++
 	d := &Dequeuer{
-		ID:       len(p.Dequeuers) + 1,
+		ID:       p.nextID,
 		QuitChan: make(chan bool, 1),
 		W:        w,
+		ctx:      ctx,
+		cancel:   cancel,
+		pool:     p,
 	}
 	p.Dequeuers = append(p.Dequeuers, d)
 	p.wg.Add(1)
@@ -156,23 +315,59 @@ func (p *Pool) RemoveDequeuer() error {
 	return nil
 }
 
-// Shutdown all workers in the pool.
+// Shutdown all workers in the pool. Shutdown waits for in-flight jobs to
+// finish, however long they take.
 func (p *Pool) Shutdown() error {
+	return p.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down all workers in the pool, and waits for in-flight
+// jobs to finish. If ctx is cancelled first, the context passed to
+// ContextWorkers is cancelled, and ShutdownContext returns ctx.Err() once
+// they return. Workers that don't implement ContextWorker can't be
+// interrupted.
+func (p *Pool) ShutdownContext(ctx context.Context) error {
+	p.mu.Lock()
 	p.receivedShutdownSignal = true
-	l := len(p.Dequeuers)
-	for i := 0; i < l; i++ {
+	p.mu.Unlock()
+	p.stopAutoscaling()
+	p.mu.Lock()
+	dequeuers := make([]*Dequeuer, len(p.Dequeuers))
+	copy(dequeuers, p.Dequeuers)
+	p.mu.Unlock()
+	for range dequeuers {
 		err := p.RemoveDequeuer()
 		if err != nil {
 			return err
 		}
 	}
-	p.wg.Wait()
-	return nil
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, d := range dequeuers {
+			if d.cancel != nil {
+				d.cancel()
+			}
+		}
+		<-done
+		return ctx.Err()
+	}
 }
 
 func (d *Dequeuer) Work(name string, wg *sync.WaitGroup) {
 	defer wg.Done()
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	failedAcquireCount := uint32(0)
+	acquireErrorCount := uint32(0)
 	waitDuration := time.Duration(0)
 	for {
 		select {
@@ -181,33 +376,107 @@ func (d *Dequeuer) Work(name string, wg *sync.WaitGroup) {
 			return
 
 		case <-time.After(waitDuration):
+			if d.pool.Paused() {
+				failedAcquireCount++
+				waitDuration = d.W.Sleep(failedAcquireCount)
+				continue
+			}
+			rateLimited := d.pool != nil && d.pool.RateLimited
+			if rateLimited {
+				ok, wait := services.TakeRateLimitToken(name)
+				if !ok {
+					waitDuration = wait
+					continue
+				}
+			}
 			start := time.Now()
-			qj, err := queued_jobs.Acquire(name)
+			qj, err := queued_jobs.AcquireWorker(name, d.pool.workerID())
 			go metrics.Time("acquire.latency", time.Since(start))
+			if err != nil && rateLimited {
+				// Nothing is being sent downstream, so give the token back.
+				services.ReturnRateLimitToken(name)
+			}
 			if err == nil {
 				failedAcquireCount = 0
+				acquireErrorCount = 0
 				waitDuration = time.Duration(0)
-				err = d.W.DoWork(qj)
+				d.setJob(qj)
+				workStart := time.Now()
+				err = work(ctx, d.W, qj)
+				d.pool.recordJob(time.Since(workStart))
+				d.setJob(nil)
 				if err != nil {
 					log.Printf("worker: Error processing job %s: %s", qj.ID.String(), err)
 					go metrics.Increment(fmt.Sprintf("dequeue.%s.error", name))
 				} else {
 					go metrics.Increment(fmt.Sprintf("dequeue.%s.success", name))
 				}
-			} else {
-				dberr, ok := err.(*dberror.Error)
-				if ok && dberr.Code == dberror.CodeLockNotAvailable {
-					// SELECT 1 returned a record but another thread
-					// got it. Don't sleep at all.
-					go metrics.Increment(fmt.Sprintf("dequeue.%s.nowait", name))
-					failedAcquireCount = 0
-					waitDuration = time.Duration(0)
-					continue
-				}
-
+				continue
+			}
+			if err == sql.ErrNoRows {
+				// No jobs available.
+				acquireErrorCount = 0
 				failedAcquireCount++
 				waitDuration = d.W.Sleep(failedAcquireCount)
+				continue
 			}
+			dberr, ok := err.(*dberror.Error)
+			if ok && dberr.Code == dberror.CodeLockNotAvailable {
+				// SELECT 1 returned a record but another thread
+				// got it. Don't sleep at all.
+				go metrics.Increment(fmt.Sprintf("dequeue.%s.nowait", name))
+				failedAcquireCount = 0
+				waitDuration = time.Duration(0)
+				continue
+			}
+			// The database is probably unavailable. Back off separately
+			// from the idle sleep, so a short outage doesn't leave us
+			// sleeping for the maximum once it's over.
+			acquireErrorCount++
+			waitDuration = acquireErrorBackoff(acquireErrorCount)
+			d.pool.recordAcquireError(err)
+			log.Printf("worker: Error acquiring %s job, retrying in %v: %s", name, waitDuration, err)
+			go metrics.Increment(fmt.Sprintf("dequeue.%s.acquire_error", name))
+			go metrics.Increment("dequeue.acquire_error")
 		}
 	}
 }
+
+const (
+	minAcquireErrorBackoff = 100 * time.Millisecond
+	maxAcquireErrorBackoff = 30 * time.Second
+)
+
+// acquireErrorBackoff returns how long to wait after failures errors in a
+// row acquiring a job: 100ms, 200ms, 400ms, ..., up to 30 seconds, with up to
+// 20% jitter so dequeuers don't all reconnect at once.
+func acquireErrorBackoff(failures uint32) time.Duration {
+	d := maxAcquireErrorBackoff
+	if failures > 0 && failures < 20 {
+		d = minAcquireErrorBackoff << (failures - 1)
+		if d > maxAcquireErrorBackoff {
+			d = maxAcquireErrorBackoff
+		}
+	}
+	return d - time.Duration(rand.Float64()*0.2*float64(d))
+}
+
+// work calls doWork, and recovers if w panics. A job that panics is marked
+// as failed, with the panic message as its result, and retried if it has
+// attempts remaining.
+func work(ctx context.Context, w Worker, qj *models.QueuedJob) (err error) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		err = fmt.Errorf("panic: %v", p)
+		log.Printf("worker: panic processing job %s (type %s): %v\n%s", qj.ID.String(), qj.Name, p, debug.Stack())
+		go metrics.Increment(fmt.Sprintf("dequeue.%s.panic", qj.Name))
+		result, _ := json.Marshal(map[string]string{"error": err.Error()})
+		if herr := services.HandleStatusCallbackResult(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true, result); herr != nil {
+			log.Printf("worker: Error marking job %s as failed after panic: %s", qj.ID.String(), herr)
+		}
+	}()
+	return doWork(ctx, w, qj)
+}
@@ -0,0 +1,140 @@
+package dequeuer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+)
+
+var _ ContextWorker = services.NewRegistry()
+var _ ContextWorker = services.NewCommandWorker()
+var _ ContextWorker = &services.JobProcessor{}
+
+func jobNames(jobs []*models.Job) []string {
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		names[i] = job.Name
+	}
+	return names
+}
+
+var allJobs = []*models.Job{
+	{Name: "render-pdf", Concurrency: 2},
+	{Name: "send-email", Concurrency: 10},
+	{Name: "send-sms", Concurrency: 5},
+}
+
+func TestSelectJobsDefault(t *testing.T) {
+	t.Parallel()
+	jobs, err := selectJobs(allJobs, PoolOptions{})
+	test.AssertNotError(t, err, "")
+	test.AssertDeepEquals(t, jobNames(jobs), []string{"render-pdf", "send-email", "send-sms"})
+}
+
+func TestSelectJobsInclude(t *testing.T) {
+	t.Parallel()
+	jobs, err := selectJobs(allJobs, PoolOptions{Include: []string{"render-pdf", "unknown"}})
+	test.AssertNotError(t, err, "")
+	test.AssertDeepEquals(t, jobNames(jobs), []string{"render-pdf"})
+}
+
+func TestSelectJobsExclude(t *testing.T) {
+	t.Parallel()
+	jobs, err := selectJobs(allJobs, PoolOptions{Exclude: []string{"render-pdf"}})
+	test.AssertNotError(t, err, "")
+	test.AssertDeepEquals(t, jobNames(jobs), []string{"send-email", "send-sms"})
+
+	jobs, err = selectJobs(allJobs, PoolOptions{
+		Include: []string{"render-pdf", "send-sms"},
+		Exclude: []string{"send-sms"},
+	})
+	test.AssertNotError(t, err, "")
+	test.AssertDeepEquals(t, jobNames(jobs), []string{"render-pdf"})
+}
+
+func TestSelectJobsNegativeConcurrency(t *testing.T) {
+	t.Parallel()
+	_, err := selectJobs(allJobs, PoolOptions{Concurrency: map[string]int{"render-pdf": -1}})
+	test.AssertError(t, err, "")
+}
+
+func TestAcquireErrorBackoff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		failures uint32
+		max      time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{10, 30 * time.Second},
+		{500, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		d := acquireErrorBackoff(tt.failures)
+		test.Assert(t, d <= tt.max, "backoff too long")
+		test.Assert(t, d >= tt.max*4/5, "backoff too short")
+	}
+}
+
+func TestDesiredDequeuers(t *testing.T) {
+	t.Parallel()
+	healthy := services.DownstreamStats{Requests: 100, Errors: 1, TotalLatency: 100 * 50 * time.Millisecond}
+	tests := []struct {
+		name   string
+		in     autoscaleInput
+		want   int
+		reason string
+	}{
+		{"no latency data", autoscaleInput{current: 1, min: 1, max: 10, ready: 3}, 2, "up"},
+		{"needed", autoscaleInput{current: 2, min: 1, max: 10, ready: 30, jobLatency: time.Second, interval: 10 * time.Second, downstream: healthy}, 3, "up"},
+		{"at most double", autoscaleInput{current: 2, min: 1, max: 10, ready: 100, jobLatency: time.Second, interval: 10 * time.Second}, 4, "up"},
+		{"from zero", autoscaleInput{current: 0, min: 0, max: 10, ready: 100}, 1, "up"},
+		{"max", autoscaleInput{current: 8, min: 1, max: 10, ready: 100}, 10, "up"},
+		{"empty queue", autoscaleInput{current: 5, min: 2, max: 10}, 4, "down"},
+		{"min", autoscaleInput{current: 2, min: 2, max: 10}, 2, "down"},
+		{"enough", autoscaleInput{current: 3, min: 1, max: 10, ready: 3}, 3, "hold"},
+		{"errors", autoscaleInput{current: 8, min: 3, max: 10, ready: 100, downstream: services.DownstreamStats{Requests: 10, Errors: 5}}, 4, "backoff"},
+		{"errors min", autoscaleInput{current: 4, min: 3, max: 10, ready: 100, downstream: services.DownstreamStats{Requests: 10, Errors: 5}}, 3, "backoff"},
+		{"few requests", autoscaleInput{current: 4, min: 1, max: 10, ready: 100, downstream: services.DownstreamStats{Requests: 2, Errors: 2}}, 8, "up"},
+		{"slow", autoscaleInput{current: 4, min: 1, max: 10, ready: 100, downstream: healthy, baseline: 20 * time.Millisecond}, 4, "slow"},
+	}
+	for _, tt := range tests {
+		got, reason := desiredDequeuers(tt.in)
+		if got != tt.want || reason != tt.reason {
+			t.Errorf("%s: got (%d, %s), want (%d, %s)", tt.name, got, reason, tt.want, tt.reason)
+		}
+	}
+}
+
+func TestShutdownStopsAutoscaler(t *testing.T) {
+	t.Parallel()
+	p := NewPool("render-pdf")
+	returned := make(chan struct{})
+	go func() {
+		p.Autoscale(services.NewRegistry(), 1, 10, time.Hour)
+		close(returned)
+	}()
+	for {
+		p.mu.Lock()
+		started := p.stopAutoscale != nil
+		p.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	err := p.ShutdownContext(context.Background())
+	test.AssertNotError(t, err, "")
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("autoscaler still running after shutdown")
+	}
+	// An autoscaler started after shutdown returns straight away.
+	p.Autoscale(services.NewRegistry(), 1, 10, time.Hour)
+}
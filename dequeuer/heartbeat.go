@@ -0,0 +1,60 @@
+package dequeuer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/workers"
+)
+
+func (p *Pool) workerID() *types.PrefixUUID {
+	if p == nil {
+		return nil
+	}
+	return p.WorkerID
+}
+
+// Heartbeat records in the workers table that the process running ps is
+// alive, along with the job types it's dequeuing and the total number of
+// dequeuers. w should have the process's ID, hostname, pid and version set.
+func (ps Pools) Heartbeat(w *models.Worker) (*models.Worker, error) {
+	hb := *w
+	hb.JobTypes = make([]string, 0, len(ps))
+	hb.Concurrency = 0
+	for _, p := range ps {
+		if p == nil {
+			continue
+		}
+		hb.JobTypes = append(hb.JobTypes, p.Name)
+		hb.Concurrency += p.Status().Dequeuers
+	}
+	return workers.Heartbeat(&hb)
+}
+
+// WatchHeartbeat calls Heartbeat every interval, forever. If the worker stops
+// sending heartbeats for services.DeadWorkerTimeout, the jobs it's holding
+// are failed.
+func (ps Pools) WatchHeartbeat(w *models.Worker, interval time.Duration) {
+	ps.WatchHeartbeatContext(context.Background(), w, interval)
+}
+
+// WatchHeartbeatContext is like WatchHeartbeat, but returns once ctx is
+// cancelled. Cancel ctx before deleting the worker, so a heartbeat doesn't
+// register it again.
+func (ps Pools) WatchHeartbeatContext(ctx context.Context, w *models.Worker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := ps.Heartbeat(w); err != nil {
+			log.Printf("Error sending worker heartbeat: %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package dequeuer
+
+import (
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+)
+
+// PoolStatus is a snapshot of what a Pool is doing.
+type PoolStatus struct {
+	Name      string `json:"name"`
+	Dequeuers int    `json:"dequeuers"`
+	// Paused is true if the pool's dequeuers have been told to stop
+	// acquiring jobs. It's separate from pausing the job type.
+	Paused bool `json:"paused"`
+	// InFlight is the jobs the pool's dequeuers are working on right now.
+	InFlight []InFlightJob `json:"in_flight"`
+	// LastAcquireError is the last error any of the pool's dequeuers got
+	// trying to acquire a job, or nil.
+	LastAcquireError *AcquireError `json:"last_acquire_error"`
+}
+
+// An InFlightJob is a job that a dequeuer is working on.
+type InFlightJob struct {
+	ID         types.PrefixUUID `json:"id"`
+	DequeuerID int              `json:"dequeuer_id"`
+	StartedAt  time.Time        `json:"started_at"`
+	ElapsedMs  int64            `json:"elapsed_ms"`
+}
+
+// An AcquireError is an error acquiring a job from the database.
+type AcquireError struct {
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// Get returns the pool with the given name, or nil if there isn't one.
+func (ps Pools) Get(name string) *Pool {
+	for _, p := range ps {
+		if p != nil && p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Pause stops the pool's dequeuers from acquiring new jobs, until Resume is
+// called. Jobs that are in flight keep running. Pause only affects this
+// process; to stop every dequeuer, pause the job type instead.
+func (p *Pool) Pause() {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.paused = true
+}
+
+// Resume lets the pool's dequeuers acquire jobs again after Pause.
+func (p *Pool) Resume() {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.paused = false
+}
+
+// Paused reports whether the pool has been paused. A nil Pool is never
+// paused.
+func (p *Pool) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	return p.paused
+}
+
+func (p *Pool) recordAcquireError(err error) {
+	if p == nil {
+		return
+	}
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.lastAcquireErr = &AcquireError{Error: err.Error(), At: time.Now().UTC()}
+}
+
+func (p *Pool) recordJob(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	p.jobCount++
+	p.jobTime += d
+}
+
+// takeJobLatency returns the average time spent on each job since the last
+// call, or 0 if no jobs have finished.
+func (p *Pool) takeJobLatency() time.Duration {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	if p.jobCount == 0 {
+		return 0
+	}
+	latency := p.jobTime / time.Duration(p.jobCount)
+	p.jobCount, p.jobTime = 0, 0
+	return latency
+}
+
+// Status returns a snapshot of the pool's dequeuers and the jobs they're
+// working on.
+func (p *Pool) Status() PoolStatus {
+	p.mu.Lock()
+	dequeuers := make([]*Dequeuer, len(p.Dequeuers))
+	copy(dequeuers, p.Dequeuers)
+	p.mu.Unlock()
+
+	now := time.Now().UTC()
+	status := PoolStatus{
+		Name:      p.Name,
+		Dequeuers: len(dequeuers),
+		InFlight:  make([]InFlightJob, 0),
+	}
+	for _, d := range dequeuers {
+		qj, started := d.currentJob()
+		if qj == nil {
+			continue
+		}
+		status.InFlight = append(status.InFlight, InFlightJob{
+			ID:         qj.ID,
+			DequeuerID: d.ID,
+			StartedAt:  started,
+			ElapsedMs:  int64(now.Sub(started) / time.Millisecond),
+		})
+	}
+	p.statusMu.Lock()
+	status.Paused = p.paused
+	status.LastAcquireError = p.lastAcquireErr
+	p.statusMu.Unlock()
+	return status
+}
+
+func (d *Dequeuer) setJob(qj *models.QueuedJob) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.job = qj
+	d.started = time.Now().UTC()
+}
+
+func (d *Dequeuer) currentJob() (*models.QueuedJob, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.job, d.started
+}
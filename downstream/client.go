@@ -38,7 +38,10 @@ type Client struct {
 	Job *JobService
 }
 
-// NewClient creates a new Client.
+// NewClient creates a new Client. Requests made with the embedded
+// rest.Client, and JobService.Post, return a *rest.Error for a 4xx or 5xx
+// response. JobService.PostResult returns a *Error, which wraps the
+// *rest.Error.
 func NewClient(id, token, base string) *Client {
 	rc := rest.NewClient(id, token, base)
 	rc.Client = &http.Client{Timeout: defaultHTTPTimeout}
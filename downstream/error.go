@@ -0,0 +1,64 @@
+package downstream
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Shyp/rest"
+)
+
+// An Error is returned when the downstream server responds with a 4xx or 5xx
+// status code.
+type Error struct {
+	StatusCode int
+
+	// Err is the parsed response body. If the body isn't a rest.Error, Err's
+	// Title contains the whole body.
+	Err *rest.Error
+
+	// RetryAfter is how long the server asked us to wait before sending the
+	// job again, from the Retry-After header. Zero if the header wasn't set.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// parseError parses a 400-or-higher response from the downstream server.
+func parseError(res *http.Response) error {
+	err := rest.DefaultErrorParser(res)
+	rerr, ok := err.(*rest.Error)
+	if !ok {
+		rerr = &rest.Error{
+			Title:      err.Error(),
+			StatusCode: res.StatusCode,
+		}
+	}
+	return &Error{
+		StatusCode: res.StatusCode,
+		Err:        rerr,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or a HTTP date. Returns zero if the header is empty, invalid, or in
+// the past.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(header)
+	if err != nil || !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
@@ -0,0 +1,50 @@
+package downstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/test"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 1, 1, 6, 0, 0, 0, time.UTC)
+	test.AssertEquals(t, parseRetryAfter("", now), time.Duration(0))
+	test.AssertEquals(t, parseRetryAfter("120", now), 2*time.Minute)
+	test.AssertEquals(t, parseRetryAfter("0", now), time.Duration(0))
+	test.AssertEquals(t, parseRetryAfter("-5", now), time.Duration(0))
+	test.AssertEquals(t, parseRetryAfter("Fri, 01 Jan 2016 06:00:30 GMT", now), 30*time.Second)
+	test.AssertEquals(t, parseRetryAfter("Fri, 01 Jan 2016 05:59:00 GMT", now), time.Duration(0))
+	test.AssertEquals(t, parseRetryAfter("soon", now), time.Duration(0))
+}
+
+func TestPostReturnsError(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("<html>Slow down</html>"))
+	}))
+	defer s.Close()
+	client := NewClient("test", "hymanrickover", s.URL)
+	id, _ := types.NewPrefixUUID("job_6740b44e-13b9-475d-af06-979627e0e0d6")
+	_, err := client.Job.PostResult(context.Background(), "invoice-shipment", &id, &JobParams{Attempts: 3})
+	derr, ok := err.(*Error)
+	test.Assert(t, ok, "expected a *downstream.Error")
+	test.AssertEquals(t, derr.StatusCode, http.StatusTooManyRequests)
+	test.AssertEquals(t, derr.RetryAfter, 30*time.Second)
+	test.AssertContains(t, derr.Error(), "Slow down")
+
+	// Post returns a *rest.Error, as it always has.
+	err = client.Job.Post("invoice-shipment", &id, &JobParams{Attempts: 3})
+	rerr, ok := err.(*rest.Error)
+	test.Assert(t, ok, "expected a *rest.Error")
+	test.AssertEquals(t, rerr.StatusCode, http.StatusTooManyRequests)
+	test.AssertContains(t, rerr.Error(), "Slow down")
+}
@@ -2,9 +2,11 @@ package downstream
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/Shyp/go-types"
 )
@@ -18,12 +20,41 @@ type JobParams struct {
 	Attempts uint8           `json:"attempts"`
 }
 
+// A JobResult is the body of a 200 response from the downstream service, for
+// a job type that reports results synchronously. The fields match the body of
+// a status callback.
+type JobResult struct {
+	// Should be "succeeded" or "failed".
+	Status string `json:"status"`
+	// Retryable indicates whether a failure is retryable. The default is
+	// true.
+	Retryable *bool `json:"retryable"`
+	// Result is optional JSON describing the outcome of the job.
+	Result json.RawMessage `json:"result"`
+}
+
 // Post makes a request to /v1/jobs/:job-name/:job-id with the job data.
 // The downstream service is expected to respond with a 202, so there is no
-// positive return value, only nil if the response was a 2xx status code.
+// positive return value, only nil if the response was a 2xx status code. A
+// 4xx or 5xx response returns a *rest.Error.
 func (j *JobService) Post(name string, id *types.PrefixUUID, jp *JobParams) error {
+	_, err := j.PostResult(context.Background(), name, id, jp)
+	if derr, ok := err.(*Error); ok {
+		return derr.Err
+	}
+	return err
+}
+
+// PostResult is like Post, but if the downstream service responds with a 200
+// and a JobResult body, the result is returned. Otherwise the result is nil,
+// and the downstream service should report the result with a callback.
+//
+// The request is cancelled if ctx is cancelled. A 4xx or 5xx response returns
+// a *Error, with the status code and Retry-After header; the client's
+// ErrorParser isn't used.
+func (j *JobService) PostResult(ctx context.Context, name string, id *types.PrefixUUID, jp *JobParams) (*JobResult, error) {
 	if jp == nil || id == nil {
-		return errors.New("no job to post")
+		return nil, errors.New("no job to post")
 	}
 	if len(jp.Data) == 0 {
 		jp.Data = []byte("null")
@@ -31,12 +62,34 @@ func (j *JobService) Post(name string, id *types.PrefixUUID, jp *JobParams) erro
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(jp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req, err := j.Client.NewRequest("POST", fmt.Sprintf("/v1/jobs/%s/%s", name, id.String()), b)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	// rest.Client.Do doesn't tell us the status code, so make the request
+	// ourselves.
+	hc := j.Client.Client.Client
+	if hc == nil {
+		hc = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return nil, parseError(res)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	result := new(JobResult)
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil || result.Status == "" {
+		// A plain 200 means the job was accepted, same as a 202.
+		return nil, nil
 	}
-	var d struct{}
-	return j.Client.Do(req, &d)
+	return result, nil
 }
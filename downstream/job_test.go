@@ -0,0 +1,48 @@
+package downstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/test"
+)
+
+func postResult(t *testing.T, status int, body string) (*JobResult, error) {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+	client := NewClient("test", "hymanrickover", s.URL)
+	id, _ := types.NewPrefixUUID("job_6740b44e-13b9-475d-af06-979627e0e0d6")
+	return client.Job.PostResult(context.Background(), "invoice-shipment", &id, &JobParams{Attempts: 3})
+}
+
+func TestPostResultSynchronous(t *testing.T) {
+	t.Parallel()
+	result, err := postResult(t, http.StatusOK, `{"status": "failed", "retryable": false, "result": {"reason": "no address"}}`)
+	test.AssertNotError(t, err, "")
+	test.AssertNotNil(t, result, "")
+	test.AssertEquals(t, result.Status, "failed")
+	test.AssertEquals(t, *result.Retryable, false)
+	test.AssertEquals(t, string(result.Result), `{"reason": "no address"}`)
+}
+
+func TestPostResultAccepted(t *testing.T) {
+	t.Parallel()
+	result, err := postResult(t, http.StatusAccepted, `{"status": "succeeded"}`)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, result == nil, "expected a nil result for a 202")
+
+	result, err = postResult(t, http.StatusOK, `{}`)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, result == nil, "expected a nil result without a status")
+
+	result, err = postResult(t, http.StatusOK, ``)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, result == nil, "expected a nil result for an empty body")
+}
@@ -19,4 +19,9 @@ type ArchivedJob struct {
 	CreatedAt time.Time        `json:"created_at"`
 	Data      json.RawMessage  `json:"data"`
 	ExpiresAt types.NullTime   `json:"expires_at"`
+	// ParentID is set on follow-up jobs to the ID of the job that enqueued
+	// them.
+	ParentID *types.PrefixUUID `json:"parent_id"`
+	// BatchID is set if the job was enqueued as part of a batch.
+	BatchID *types.PrefixUUID `json:"batch_id"`
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/Shyp/go-dberror"
 	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
 	"github.com/Shyp/rickover/models/db"
 	"github.com/Shyp/rickover/models/queued_jobs"
 )
@@ -22,6 +23,7 @@ var ErrNotFound = errors.New("Archived job not found")
 
 var createStmt *sql.Stmt
 var getStmt *sql.Stmt
+var followUpDepthStmt *sql.Stmt
 
 // Setup prepares all database statements.
 func Setup() (err error) {
@@ -35,7 +37,7 @@ func Setup() (err error) {
 
 	query := fmt.Sprintf(`-- archived_jobs.Create
 INSERT INTO archived_jobs (%s) 
-SELECT id, $2, $4, $3, data, expires_at
+SELECT id, $2, $4, $3, data, expires_at, parent_id, batch_id
 FROM queued_jobs 
 WHERE id=$1
 AND name=$2
@@ -50,6 +52,20 @@ SELECT %s
 FROM archived_jobs
 WHERE id = $1`, fields())
 	getStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	followUpDepthStmt, err = db.Conn.Prepare(`-- archived_jobs.FollowUpDepth
+WITH RECURSIVE chain(parent_id, depth) AS (
+	SELECT parent_id, 0 FROM archived_jobs WHERE id = $1
+	UNION ALL
+	SELECT archived_jobs.parent_id, chain.depth + 1
+	FROM archived_jobs, chain
+	WHERE archived_jobs.id = chain.parent_id
+		AND chain.depth < $2
+)
+SELECT GREATEST(count(*) - 1, 0) FROM chain`)
 	return
 }
 
@@ -57,9 +73,18 @@ WHERE id = $1`, fields())
 // the job already exists in the queued_jobs table; the `data` field is copied
 // from there. If the job does not exist, queued_jobs.ErrNotFound is returned.
 func Create(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8) (*models.ArchivedJob, error) {
+	return create(createStmt, id, name, status, attempt)
+}
+
+// CreateTx is like Create, but runs inside the given transaction.
+func CreateTx(tx *sql.Tx, id types.PrefixUUID, name string, status models.JobStatus, attempt uint8) (*models.ArchivedJob, error) {
+	return create(tx.Stmt(createStmt), id, name, status, attempt)
+}
+
+func create(stmt *sql.Stmt, id types.PrefixUUID, name string, status models.JobStatus, attempt uint8) (*models.ArchivedJob, error) {
 	aj := new(models.ArchivedJob)
 	var bt []byte
-	err := createStmt.QueryRow(id, name, status, attempt).Scan(args(aj, &bt)...)
+	err := stmt.QueryRow(id, name, status, attempt).Scan(args(aj, &bt)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, queued_jobs.ErrNotFound
@@ -71,6 +96,16 @@ func Create(id types.PrefixUUID, name string, status models.JobStatus, attempt u
 	return aj, nil
 }
 
+// FollowUpDepthTx returns the number of archived jobs in the chain of
+// parent_ids above the archived job with the given id, up to max.
+func FollowUpDepthTx(tx *sql.Tx, id types.PrefixUUID, max int) (depth int, err error) {
+	err = tx.Stmt(followUpDepthStmt).QueryRow(id, max).Scan(&depth)
+	if err != nil {
+		err = dberror.GetError(err)
+	}
+	return
+}
+
 // Get returns the archived job with the given id, or sql.ErrNoRows if it's
 // not present.
 func Get(id types.PrefixUUID) (*models.ArchivedJob, error) {
@@ -109,7 +144,9 @@ func insertFields() string {
 	attempts,
 	status,
 	data,
-	expires_at`
+	expires_at,
+	parent_id,
+	batch_id`
 }
 
 func fields() string {
@@ -119,7 +156,9 @@ func fields() string {
 	status,
 	data,
 	created_at,
-	expires_at`, Prefix)
+	expires_at,
+	'%s' || parent_id,
+	'%s' || batch_id`, Prefix, Prefix, batches.Prefix)
 }
 
 func args(aj *models.ArchivedJob, byteptr *[]byte) []interface{} {
@@ -132,5 +171,7 @@ func args(aj *models.ArchivedJob, byteptr *[]byte) []interface{} {
 		byteptr,
 		&aj.CreatedAt,
 		&aj.ExpiresAt,
+		&aj.ParentID,
+		&aj.BatchID,
 	}
 }
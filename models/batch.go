@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Shyp/go-types"
+)
+
+// A Batch groups jobs together, so you can tell when all of them have
+// finished.
+//
+// Jobs can be added to a batch until it's closed. Once a batch is closed and
+// every job in it has been archived, the batch is complete, and a job of type
+// OnComplete is enqueued, if set.
+type Batch struct {
+	ID types.PrefixUUID `json:"id"`
+	// OnComplete is the job type to enqueue when the batch completes.
+	OnComplete types.NullString `json:"on_complete"`
+	// Data is passed to the OnComplete job.
+	Data        json.RawMessage `json:"data"`
+	ClosedAt    types.NullTime  `json:"closed_at"`
+	CompletedAt types.NullTime  `json:"completed_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
@@ -0,0 +1,206 @@
+// Logic for interacting with the "batches" table.
+package batches
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+)
+
+const Prefix = "batch_"
+
+// ErrNotFound indicates that the batch was not found.
+var ErrNotFound = errors.New("Batch not found")
+
+// ErrClosed indicates that the batch has been closed, so no more jobs can be
+// enqueued into it.
+var ErrClosed = errors.New("Batch is closed")
+
+// ErrNotComplete indicates that the batch can't be marked complete, because
+// it's still open, it has jobs that haven't been archived, or it's already
+// been marked complete.
+var ErrNotComplete = errors.New("Batch is not complete")
+
+var createStmt *sql.Stmt
+var getStmt *sql.Stmt
+var closeStmt *sql.Stmt
+var completeStmt *sql.Stmt
+var countsStmt *sql.Stmt
+
+// Setup prepares all database statements.
+func Setup() (err error) {
+	if !db.Connected() {
+		return errors.New("No DB connection was established, can't query")
+	}
+
+	if createStmt != nil {
+		return
+	}
+
+	query := fmt.Sprintf(`-- batches.Create
+INSERT INTO batches (id, on_complete, data)
+VALUES ($1, $2, $3)
+RETURNING %s`, fields())
+	createStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- batches.Get
+SELECT %s
+FROM batches
+WHERE id = $1`, fields())
+	getStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- batches.Close
+UPDATE batches
+SET closed_at = COALESCE(closed_at, now())
+WHERE id = $1
+RETURNING %s`, fields())
+	closeStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- batches.Complete
+UPDATE batches
+SET completed_at = now()
+WHERE id = $1
+	AND closed_at IS NOT NULL
+	AND completed_at IS NULL
+	AND NOT EXISTS (
+		SELECT id FROM queued_jobs WHERE batch_id = $1
+	)
+RETURNING %s`, fields())
+	completeStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	countsStmt, err = db.Conn.Prepare(`-- batches.GetCounts
+SELECT status::text, count(*) FROM queued_jobs WHERE batch_id = $1 GROUP BY status
+UNION ALL
+SELECT status::text, count(*) FROM archived_jobs WHERE batch_id = $1 GROUP BY status`)
+	return
+}
+
+// Create creates a new batch with the given ID. The batch is open, so jobs
+// can be enqueued into it.
+func Create(b models.Batch) (*models.Batch, error) {
+	if b.ID.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	if b.Data == nil {
+		b.Data = json.RawMessage("{}")
+	}
+	return scan(createStmt.QueryRow(b.ID, b.OnComplete, []byte(b.Data)))
+}
+
+// Get returns the batch with the given id, or ErrNotFound.
+func Get(id types.PrefixUUID) (*models.Batch, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	return scan(getStmt.QueryRow(id))
+}
+
+// Close stops new jobs from being enqueued into the batch. Closing a batch
+// that's already closed has no effect. Returns ErrNotFound if the batch
+// doesn't exist.
+func Close(id types.PrefixUUID) (*models.Batch, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	return scan(closeStmt.QueryRow(id))
+}
+
+// CompleteTx marks the batch as complete inside the given transaction, if
+// it's closed and none of its jobs are left in the queued_jobs table. If the
+// batch can't be completed, or another process already completed it,
+// ErrNotComplete is returned.
+func CompleteTx(tx *sql.Tx, id types.PrefixUUID) (*models.Batch, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	b, err := scan(tx.Stmt(completeStmt).QueryRow(id))
+	if err == ErrNotFound {
+		return nil, ErrNotComplete
+	}
+	return b, err
+}
+
+// GetCounts returns the number of jobs in the batch with each status, across
+// the queued_jobs and archived_jobs tables. Statuses with no jobs are omitted.
+func GetCounts(id types.PrefixUUID) (map[models.JobStatus]int64, error) {
+	return getCounts(countsStmt, id)
+}
+
+// GetCountsTx is like GetCounts, but runs inside the given transaction.
+func GetCountsTx(tx *sql.Tx, id types.PrefixUUID) (map[models.JobStatus]int64, error) {
+	return getCounts(tx.Stmt(countsStmt), id)
+}
+
+func getCounts(stmt *sql.Stmt, id types.PrefixUUID) (map[models.JobStatus]int64, error) {
+	m := make(map[models.JobStatus]int64)
+	if id.UUID == nil {
+		return m, errors.New("Invalid id")
+	}
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status models.JobStatus
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return m, err
+		}
+		m[status] += count
+	}
+	err = rows.Err()
+	return m, err
+}
+
+func scan(row *sql.Row) (*models.Batch, error) {
+	b := new(models.Batch)
+	var bt []byte
+	err := row.Scan(args(b, &bt)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	b.Data = json.RawMessage(bt)
+	return b, nil
+}
+
+func fields() string {
+	return fmt.Sprintf(`'%s' || id,
+on_complete,
+data,
+closed_at,
+completed_at,
+created_at`, Prefix)
+}
+
+func args(b *models.Batch, byteptr *[]byte) []interface{} {
+	return []interface{}{
+		&b.ID,
+		&b.OnComplete,
+		// can't scan into Data because of https://github.com/golang/go/issues/13905
+		byteptr,
+		&b.ClosedAt,
+		&b.CompletedAt,
+		&b.CreatedAt,
+	}
+}
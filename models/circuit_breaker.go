@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Shyp/go-types"
+)
+
+// CircuitState describes whether jobs of a type are being sent downstream.
+type CircuitState string
+
+// CircuitClosed means jobs are sent downstream as usual.
+const CircuitClosed = CircuitState("closed")
+
+// CircuitOpen means the downstream server has failed too many times in a
+// row, and jobs of this type won't be sent until OpenUntil.
+const CircuitOpen = CircuitState("open")
+
+// CircuitHalfOpen means a single job has been sent downstream to check
+// whether the server has recovered.
+const CircuitHalfOpen = CircuitState("half_open")
+
+// A CircuitBreaker tracks consecutive downstream failures for a job type.
+type CircuitBreaker struct {
+	// Name is the name of the job type.
+	Name  string       `json:"name"`
+	State CircuitState `json:"state"`
+	// Failures is the number of consecutive failed requests to the
+	// downstream server.
+	Failures uint32 `json:"failures"`
+	// OpenUntil is when the next probe can be sent, if the circuit is open,
+	// or when the current probe is given up on, if it's half open.
+	OpenUntil types.NullTime `json:"open_until"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
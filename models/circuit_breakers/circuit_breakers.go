@@ -0,0 +1,166 @@
+// Logic for interacting with the "circuit_breakers" table.
+package circuit_breakers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+)
+
+// ErrNotFound indicates that the job type has no circuit breaker. A job type
+// gets one the first time a request to the downstream server fails.
+var ErrNotFound = errors.New("Circuit breaker not found")
+
+// ErrNoProbe indicates that the circuit isn't ready to send a probe, either
+// because it's closed, it's still cooling down, or another dequeuer is
+// already sending one.
+var ErrNoProbe = errors.New("Circuit breaker is not ready for a probe")
+
+var getStmt *sql.Stmt
+var failureStmt *sql.Stmt
+var halfOpenStmt *sql.Stmt
+var closeStmt *sql.Stmt
+
+// Setup prepares all database statements.
+func Setup() (err error) {
+	if !db.Connected() {
+		return errors.New("No DB connection was established, can't query")
+	}
+
+	if getStmt != nil {
+		return
+	}
+
+	query := fmt.Sprintf(`-- circuit_breakers.Get
+SELECT %s
+FROM circuit_breakers
+WHERE name = $1`, fields())
+	getStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	// A failed probe opens the circuit again straight away; otherwise it
+	// opens when the failure count reaches the threshold. Failures while the
+	// circuit is already open don't push back open_until.
+	query = fmt.Sprintf(`-- circuit_breakers.RecordFailure
+INSERT INTO circuit_breakers AS cb (name, state, failures, open_until)
+VALUES (
+	$1,
+	CASE WHEN $2::integer <= 1 THEN '%[1]s'::circuit_state ELSE '%[2]s'::circuit_state END,
+	1,
+	CASE WHEN $2::integer <= 1 THEN now() + $3::integer * interval '1 millisecond' END
+)
+ON CONFLICT (name) DO UPDATE
+SET failures = cb.failures + 1,
+	state = CASE
+		WHEN cb.state = '%[3]s' OR cb.failures + 1 >= $2::integer THEN '%[1]s'::circuit_state
+		ELSE cb.state
+	END,
+	open_until = CASE
+		WHEN cb.state = '%[3]s' OR (cb.state = '%[2]s' AND cb.failures + 1 >= $2::integer)
+			THEN now() + $3::integer * interval '1 millisecond'
+		ELSE cb.open_until
+	END,
+	updated_at = now()
+RETURNING %[4]s`, models.CircuitOpen, models.CircuitClosed, models.CircuitHalfOpen, fields())
+	failureStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- circuit_breakers.HalfOpen
+UPDATE circuit_breakers
+SET state = '%s',
+	open_until = now() + $2::integer * interval '1 millisecond',
+	updated_at = now()
+WHERE name = $1
+	AND state != '%s'
+	AND open_until <= now()
+RETURNING %s`, models.CircuitHalfOpen, models.CircuitClosed, fields())
+	halfOpenStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- circuit_breakers.Close
+UPDATE circuit_breakers
+SET state = '%[1]s',
+	failures = 0,
+	open_until = NULL,
+	updated_at = now()
+WHERE name = $1
+	AND (state != '%[1]s' OR failures > 0)
+RETURNING %[2]s`, models.CircuitClosed, fields())
+	closeStmt, err = db.Conn.Prepare(query)
+	return
+}
+
+// Get returns the circuit breaker for the given job type, or ErrNotFound.
+func Get(name string) (*models.CircuitBreaker, error) {
+	return scan(getStmt.QueryRow(name))
+}
+
+// RecordFailure counts a failed request to the downstream server. The circuit
+// opens for cooldown once there have been threshold consecutive failures, or
+// if the failed request was a probe.
+func RecordFailure(name string, threshold uint32, cooldown time.Duration) (*models.CircuitBreaker, error) {
+	return scan(failureStmt.QueryRow(name, threshold, durationMs(cooldown)))
+}
+
+// HalfOpen claims the right to send a single probe to the downstream server,
+// if the circuit has finished cooling down. If the probe doesn't succeed or
+// fail within timeout, another dequeuer can send one. Returns ErrNoProbe if
+// the circuit isn't ready for a probe.
+func HalfOpen(name string, timeout time.Duration) (*models.CircuitBreaker, error) {
+	cb, err := scan(halfOpenStmt.QueryRow(name, durationMs(timeout)))
+	if err == ErrNotFound {
+		return nil, ErrNoProbe
+	}
+	return cb, err
+}
+
+// Close closes the circuit and resets the failure count. Returns ErrNotFound
+// if the circuit was already closed with no failures, so there was nothing
+// to do.
+func Close(name string) (*models.CircuitBreaker, error) {
+	return scan(closeStmt.QueryRow(name))
+}
+
+func durationMs(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func scan(row *sql.Row) (*models.CircuitBreaker, error) {
+	cb := new(models.CircuitBreaker)
+	err := row.Scan(args(cb)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	return cb, nil
+}
+
+func fields() string {
+	return `name,
+state,
+failures,
+open_until,
+updated_at`
+}
+
+func args(cb *models.CircuitBreaker) []interface{} {
+	return []interface{}{
+		&cb.Name,
+		&cb.State,
+		&cb.Failures,
+		&cb.OpenUntil,
+		&cb.UpdatedAt,
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"time"
+
+	"github.com/Shyp/go-types"
 )
 
 // A Job is an in-memory representation of a record in the jobs table.
@@ -15,6 +17,38 @@ type Job struct {
 	Attempts         uint8            `json:"attempts"`
 	Concurrency      uint8            `json:"concurrency"`
 	CreatedAt        time.Time        `json:"created_at"`
+	// OnSuccess is the name of a job type to enqueue after a job of this type
+	// succeeds.
+	OnSuccess types.NullString `json:"on_success"`
+	// OnFailure is the name of a job type to enqueue after a job of this type
+	// fails. It isn't enqueued for jobs that expire.
+	OnFailure types.NullString `json:"on_failure"`
+	// OnExpire is the name of a job type to enqueue after a job of this type
+	// expires.
+	OnExpire types.NullString `json:"on_expire"`
+	// Paused is true if dequeuers should stop acquiring jobs of this type.
+	// Jobs can still be enqueued while a job type is paused.
+	Paused bool `json:"paused"`
+	// PausedUntil is set if the job type should resume automatically at the
+	// given time.
+	PausedUntil types.NullTime `json:"paused_until"`
+	// RateLimit is the number of jobs of this type that can be sent
+	// downstream every RateIntervalMs milliseconds, across all dequeuers. Zero
+	// means there's no limit.
+	RateLimit      uint32 `json:"rate_limit"`
+	RateIntervalMs uint32 `json:"rate_interval_ms"`
+	// RateBurst is the number of jobs that can be sent at once after the job
+	// type has been idle.
+	RateBurst uint32 `json:"rate_burst"`
+	// Synchronous job types can report their result in the response to the
+	// downstream request, instead of with a callback.
+	Synchronous bool `json:"synchronous"`
+	// If MaxConcurrency is greater than zero, dequeuers adjust the number of
+	// dequeuers for this job type between MinConcurrency and MaxConcurrency,
+	// based on the number of ready jobs and how the downstream server is
+	// doing. Concurrency is the number they start with.
+	MinConcurrency uint8 `json:"min_concurrency"`
+	MaxConcurrency uint8 `json:"max_concurrency"`
 }
 
 // DeliveryStrategy describes how a job should be run. If it's safe to run a
@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	dberror "github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/db"
 	"github.com/lib/pq"
@@ -16,11 +18,13 @@ import (
 func init() {
 	dberror.RegisterConstraint(concurrencyConstraint)
 	dberror.RegisterConstraint(attemptsConstraint)
+	dberror.RegisterConstraint(autoscaleConstraint)
 }
 
 var insertJobStmt *sql.Stmt
 var getJobStmt *sql.Stmt
 var getAllJobStmt *sql.Stmt
+var pauseJobStmt *sql.Stmt
 
 // Setup prepares all database queries in this package.
 func Setup() (err error) {
@@ -32,9 +36,15 @@ func Setup() (err error) {
 		return
 	}
 
+	// Every job type gets a rate limit bucket, which starts full.
 	insertJobStmt, err = db.Conn.Prepare(fmt.Sprintf(`-- jobs.Create
-INSERT INTO jobs (%s) VALUES ($1, $2, $3, $4) RETURNING %s`,
-		fields(false), fields(true)))
+WITH job AS (
+	INSERT INTO jobs (%s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING %s
+), bucket AS (
+	INSERT INTO rate_limits (name, tokens) SELECT name, rate_burst FROM job
+)
+SELECT %s FROM job`,
+		fields(false), fields(true), fields(true)))
 	if err != nil {
 		return err
 	}
@@ -54,14 +64,36 @@ FROM jobs`, fields(true)))
 		return err
 	}
 
+	pauseJobStmt, err = db.Conn.Prepare(fmt.Sprintf(`-- jobs.Pause
+UPDATE jobs
+SET paused = $2,
+	paused_until = $3
+WHERE name = $1
+RETURNING %s`, fields(true)))
+	if err != nil {
+		return err
+	}
+
 	return
 }
 
+// DefaultRateIntervalMs is the rate limit interval for job types that don't
+// set one.
+const DefaultRateIntervalMs = 1000
+
 func Create(job models.Job) (*models.Job, error) {
+	if job.RateIntervalMs == 0 {
+		job.RateIntervalMs = DefaultRateIntervalMs
+	}
+	if job.RateBurst == 0 {
+		job.RateBurst = job.RateLimit
+	}
 	dbJob := new(models.Job)
-	err := insertJobStmt.QueryRow(job.Name, job.DeliveryStrategy, job.Attempts, job.Concurrency).Scan(args(dbJob)...)
+	err := insertJobStmt.QueryRow(job.Name, job.DeliveryStrategy, job.Attempts, job.Concurrency, job.OnSuccess, job.OnFailure, job.OnExpire, job.RateLimit, job.RateIntervalMs, job.RateBurst, job.Synchronous, job.MinConcurrency, job.MaxConcurrency).Scan(args(dbJob)...)
 	if err != nil {
 		err = dberror.GetError(err)
+	} else {
+		forget(job.Name)
 	}
 	return dbJob, err
 }
@@ -91,6 +123,26 @@ func GetAll() ([]*models.Job, error) {
 	return jobs, err
 }
 
+// Pause stops dequeuers from acquiring jobs of the given type. If until is
+// valid, the job type resumes automatically at that time. Jobs that are
+// already in progress aren't affected. Returns sql.ErrNoRows if the job type
+// doesn't exist.
+func Pause(name string, until types.NullTime) (*models.Job, error) {
+	job := new(models.Job)
+	err := pauseJobStmt.QueryRow(name, true, until).Scan(args(job)...)
+	forget(name)
+	return job, err
+}
+
+// Resume lets dequeuers acquire jobs of the given type again. Returns
+// sql.ErrNoRows if the job type doesn't exist.
+func Resume(name string) (*models.Job, error) {
+	job := new(models.Job)
+	err := pauseJobStmt.QueryRow(name, false, types.NullTime{Valid: false}).Scan(args(job)...)
+	forget(name)
+	return job, err
+}
+
 // GetRetry attempts to get the job `attempts` times before giving up.
 func GetRetry(name string, attempts uint8) (job *models.Job, err error) {
 	for i := uint8(0); i < attempts; i++ {
@@ -103,18 +155,83 @@ func GetRetry(name string, attempts uint8) (job *models.Job, err error) {
 	return
 }
 
+// CacheTTL is how long GetCached remembers a job type.
+var CacheTTL = time.Minute
+
+type cachedJob struct {
+	job     models.Job
+	expires time.Time
+}
+
+var cacheMu sync.Mutex
+var cache = make(map[string]cachedJob)
+
+// GetCached is like GetRetry, but returns the job type from memory if it was
+// read in the last CacheTTL, so it can be called for every job.
+//
+// The result can be up to CacheTTL out of date: pausing or resuming a job type
+// clears this process's cache, but other processes keep their copy until it
+// expires. Only use GetCached for settings that are fixed when the job type
+// is created - the delivery strategy, follow-ups and whether it's
+// synchronous. Pausing, rate limits and circuit breakers are checked in the
+// database as each job is acquired.
+func GetCached(name string) (*models.Job, error) {
+	cacheMu.Lock()
+	c, ok := cache[name]
+	cacheMu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		job := c.job
+		return &job, nil
+	}
+	job, err := GetRetry(name, 3)
+	if err != nil {
+		return job, err
+	}
+	cacheMu.Lock()
+	cache[name] = cachedJob{job: *job, expires: time.Now().Add(CacheTTL)}
+	cacheMu.Unlock()
+	return job, nil
+}
+
+// forget removes the job type from the GetCached cache.
+func forget(name string) {
+	cacheMu.Lock()
+	delete(cache, name)
+	cacheMu.Unlock()
+}
+
 func fields(includeCreatedAt bool) string {
 	if includeCreatedAt {
 		return `name,
 delivery_strategy,
 attempts,
 concurrency,
-created_at`
+created_at,
+on_success,
+on_failure,
+on_expire,
+paused,
+paused_until,
+rate_limit,
+rate_interval_ms,
+rate_burst,
+synchronous,
+min_concurrency,
+max_concurrency`
 	} else {
 		return `name,
 delivery_strategy,
 attempts,
-concurrency`
+concurrency,
+on_success,
+on_failure,
+on_expire,
+rate_limit,
+rate_interval_ms,
+rate_burst,
+synchronous,
+min_concurrency,
+max_concurrency`
 	}
 }
 
@@ -125,6 +242,17 @@ func args(job *models.Job) []interface{} {
 		&job.Attempts,
 		&job.Concurrency,
 		&job.CreatedAt,
+		&job.OnSuccess,
+		&job.OnFailure,
+		&job.OnExpire,
+		&job.Paused,
+		&job.PausedUntil,
+		&job.RateLimit,
+		&job.RateIntervalMs,
+		&job.RateBurst,
+		&job.Synchronous,
+		&job.MinConcurrency,
+		&job.MaxConcurrency,
 	}
 }
 
@@ -153,3 +281,16 @@ var attemptsConstraint = &dberror.Constraint{
 		}
 	},
 }
+
+var autoscaleConstraint = &dberror.Constraint{
+	Name: "jobs_autoscale_check",
+	GetError: func(e *pq.Error) *dberror.Error {
+		return &dberror.Error{
+			Message:    "Concurrency must be between min_concurrency and max_concurrency",
+			Constraint: e.Constraint,
+			Table:      e.Table,
+			Severity:   e.Severity,
+			Detail:     e.Detail,
+		}
+	},
+}
@@ -17,10 +17,15 @@ const StatusQueued = JobStatus("queued")
 // worked on.
 const StatusInProgress = JobStatus("in-progress")
 
+// StatusBlocked indicates a QueuedJob is waiting for the jobs it depends on
+// to succeed before it can be dequeued.
+const StatusBlocked = JobStatus("blocked")
+
 // A QueuedJob is a job to be run at a point in the future.
 //
-// QueuedJobs can have the status "queued" (to be run at some point), or
-// "in-progress" (a dequeuer is acting on them).
+// QueuedJobs can have the status "queued" (to be run at some point),
+// "in-progress" (a dequeuer is acting on them), or "blocked" (waiting on the
+// jobs in DependsOn).
 type QueuedJob struct {
 	ID        types.PrefixUUID `json:"id"`
 	Name      string           `json:"name"`
@@ -31,4 +36,26 @@ type QueuedJob struct {
 	UpdatedAt time.Time        `json:"updated_at"`
 	Status    JobStatus        `json:"status"`
 	Data      json.RawMessage  `json:"data"`
+	// DebounceKey is set for jobs enqueued with a debounce key, and cleared
+	// once the job is acquired.
+	DebounceKey types.NullString `json:"debounce_key"`
+	// DependsOn lists the jobs that must succeed before this job can run.
+	DependsOn []types.PrefixUUID `json:"depends_on"`
+	// ParentID is set on follow-up jobs to the ID of the job that enqueued
+	// them.
+	ParentID *types.PrefixUUID `json:"parent_id"`
+	// BatchID is set if the job was enqueued as part of a batch.
+	BatchID *types.PrefixUUID `json:"batch_id"`
+	// ConcurrencyKey is an optional key shared by jobs that shouldn't run at
+	// the same time. At most ConcurrencyLimit jobs with the same key can be in
+	// progress at once, across all job types.
+	ConcurrencyKey   types.NullString `json:"concurrency_key"`
+	ConcurrencyLimit uint8            `json:"concurrency_limit"`
+	// OrderingKey is an optional key for jobs that must run one at a time,
+	// in the order they were enqueued. A job with an ordering key can't be
+	// acquired until every older job with the same key has been archived.
+	OrderingKey types.NullString `json:"ordering_key"`
+	// WorkerID is the dequeuer process that's working on the job, if it's
+	// in progress.
+	WorkerID *types.PrefixUUID `json:"worker_id"`
 }
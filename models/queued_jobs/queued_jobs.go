@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Shyp/go-dberror"
 	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
 	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/workers"
 )
 
 const Prefix = "job_"
@@ -19,6 +22,14 @@ const Prefix = "job_"
 // ErrNotFound indicates that the job was not found.
 var ErrNotFound = errors.New("Queued job not found")
 
+// ErrNotQueued indicates that the job exists, but it's already been acquired
+// by a dequeuer, so it can't be updated.
+var ErrNotQueued = errors.New("Queued job is already in progress")
+
+// ErrBlocked indicates that the job exists, but it's waiting on the jobs it
+// depends on, so it can't be updated.
+var ErrBlocked = errors.New("Queued job is blocked on other jobs")
+
 // UnknownOrArchivedError is raised when the job type is unknown or the job has
 // already been archived. It's unfortunate we can't distinguish these, but more
 // important to minimize the total number of queries to the database.
@@ -34,18 +45,35 @@ func (e *UnknownOrArchivedError) Error() string {
 }
 
 var enqueueStmt *sql.Stmt
+var enqueueDebouncedStmt *sql.Stmt
+var unblockStmt *sql.Stmt
+var getBlockedDependentsStmt *sql.Stmt
+var getResolvableBlockedJobsStmt *sql.Stmt
 var getStmt *sql.Stmt
 var deleteStmt *sql.Stmt
 var acquireStmt *sql.Stmt
+var lockConcurrencyKeyStmt *sql.Stmt
+var countConcurrencyKeyStmt *sql.Stmt
+var releaseStmt *sql.Stmt
+var acceptedStmt *sql.Stmt
 var decrementStmt *sql.Stmt
+var updateStmt *sql.Stmt
+var runNowStmt *sql.Stmt
 var countReadyAndAllStmt *sql.Stmt
+var countReadyStmt *sql.Stmt
 var countsByStatusStmt *sql.Stmt
 var oldJobsStmt *sql.Stmt
+var deadWorkerJobsStmt *sql.Stmt
+var acquireExpiredStmt *sql.Stmt
 
 // StuckJobLimit is the maximum number of stuck jobs to fetch in one database
 // query.
 var StuckJobLimit = 100
 
+// ExpiredJobLimit is the maximum number of expired jobs to acquire in one
+// database query.
+var ExpiredJobLimit = 100
+
 func Setup() (err error) {
 	if !db.Connected() {
 		return errors.New("No DB connection was established, can't query")
@@ -55,20 +83,76 @@ func Setup() (err error) {
 		return
 	}
 
-	query := fmt.Sprintf(`-- queued_jobs.Enqueue
-INSERT INTO queued_jobs (%s) 
-SELECT $1, name, attempts, $3, $4, '%s', $5
-FROM jobs 
+	// If the job is in a batch, lock the batch row, so it can't be closed (and
+	// completed) while the job is being inserted.
+	enqueueQuery := `-- queued_jobs.%s
+INSERT INTO queued_jobs (%s, debounce_key, depends_on, batch_id, parent_id, concurrency_key, concurrency_limit, ordering_key)
+SELECT $1, name, attempts, $3, $4, $6::job_status, $5, $7, $8::uuid[], $9::uuid, $10, $11, $12, $13
+FROM jobs
 WHERE name=$2
 AND NOT EXISTS (
 	SELECT id FROM archived_jobs WHERE id=$1
 )
-RETURNING %s`, insertFields(), models.StatusQueued, fields())
+AND ($9::uuid IS NULL OR EXISTS (
+	SELECT id FROM batches WHERE id=$9::uuid AND closed_at IS NULL FOR SHARE
+))
+%s
+RETURNING %s`
+	query := fmt.Sprintf(enqueueQuery, "Enqueue", insertFields(), "", fields())
 	enqueueStmt, err = db.Conn.Prepare(query)
 	if err != nil {
 		return err
 	}
 
+	// A debounce key only ever conflicts with another queued job.
+	onConflict := fmt.Sprintf(`ON CONFLICT (name, debounce_key) WHERE status='%s'
+DO UPDATE SET run_after = excluded.run_after,
+	expires_at = excluded.expires_at,
+	data = excluded.data,
+	updated_at = now()`, models.StatusQueued)
+	query = fmt.Sprintf(enqueueQuery, "EnqueueDebounced", insertFields(), onConflict, fields())
+	enqueueDebouncedStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.Unblock
+UPDATE queued_jobs
+SET status = '%s',
+	updated_at = now()
+WHERE id = $1
+	AND status = '%s'
+RETURNING %s`, models.StatusQueued, models.StatusBlocked, fields())
+	unblockStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.GetBlockedDependents
+SELECT %s
+FROM queued_jobs
+WHERE status = '%s'
+	AND depends_on @> ARRAY[$1::uuid]`, fields(), models.StatusBlocked)
+	getBlockedDependentsStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.GetResolvableBlockedJobs
+SELECT %s
+FROM queued_jobs
+WHERE status = '%s'
+	AND NOT EXISTS (
+		SELECT id
+		FROM queued_jobs parent
+		WHERE parent.id = ANY(queued_jobs.depends_on)
+	)
+LIMIT %d`, fields(), models.StatusBlocked, StuckJobLimit)
+	getResolvableBlockedJobsStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
 	query = fmt.Sprintf(`-- queued_jobs.Get
 SELECT %s
 FROM queued_jobs
@@ -92,27 +176,97 @@ WITH queued_job as (
 	WHERE status='%[1]s'
 		AND name = $1
 		AND run_after <= now()
+		AND (concurrency_key IS NULL OR concurrency_limit > (
+			SELECT count(*)
+			FROM queued_jobs in_progress
+			WHERE in_progress.concurrency_key = queued_jobs.concurrency_key
+				AND in_progress.status = '%[2]s'
+		))
+		AND (ordering_key IS NULL OR NOT EXISTS (
+			SELECT id
+			FROM queued_jobs earlier
+			WHERE earlier.ordering_key = queued_jobs.ordering_key
+				AND (earlier.created_at, earlier.id) < (queued_jobs.created_at, queued_jobs.id)
+		))
+		AND NOT EXISTS (
+			SELECT name
+			FROM jobs
+			WHERE jobs.name = $1
+				AND paused
+				AND (paused_until IS NULL OR paused_until > now())
+		)
+		AND NOT EXISTS (
+			SELECT name
+			FROM circuit_breakers
+			WHERE circuit_breakers.name = $1
+				AND state != '%[4]s'
+				AND open_until > now()
+		)
 	ORDER BY created_at ASC
 	LIMIT 1
 	FOR UPDATE
 ) UPDATE queued_jobs
 SET status='%[2]s',
-	updated_at=now()
+	updated_at=now(),
+	debounce_key=NULL,
+	worker_id=$2
 FROM queued_job
 WHERE queued_jobs.id = queued_job.inner_id 
 	AND status='%[1]s'
-RETURNING %[3]s`, models.StatusQueued, models.StatusInProgress, fields())
+RETURNING %[3]s`, models.StatusQueued, models.StatusInProgress, fields(), models.CircuitClosed)
 	acquireStmt, err = db.Conn.Prepare(query)
 	if err != nil {
 		return err
 	}
 
+	// The two-key form keeps these locks apart from the leader's lock.
+	query = `-- queued_jobs.LockConcurrencyKey
+SELECT pg_advisory_xact_lock(hashtext('queued_jobs.concurrency_key'), hashtext($1))`
+	lockConcurrencyKeyStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.CountConcurrencyKey
+SELECT count(*)
+FROM queued_jobs
+WHERE concurrency_key = $1
+	AND status = '%s'`, models.StatusInProgress)
+	countConcurrencyKeyStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.Release
+UPDATE queued_jobs
+SET status = '%s',
+	run_after = COALESCE($2, run_after),
+	worker_id = NULL,
+	updated_at = now()
+WHERE id = $1
+	AND status = '%s'`, models.StatusQueued, models.StatusInProgress)
+	releaseStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.Accepted
+UPDATE queued_jobs
+SET worker_id = NULL
+WHERE id = $1
+	AND status = '%s'`, models.StatusInProgress)
+	acceptedStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
 	query = fmt.Sprintf(`-- queued_jobs.Decrement
 UPDATE queued_jobs
 SET status = '%s',
 	updated_at = now(),
 	attempts = attempts - 1,
-	run_after = $3
+	run_after = $3,
+	worker_id = NULL
 WHERE id = $1
 	AND attempts=$2
 	RETURNING %s`, models.StatusQueued, fields())
@@ -121,19 +275,71 @@ WHERE id = $1
 		return err
 	}
 
-	query = `-- queued_jobs.CountReadyAndAll
+	query = fmt.Sprintf(`-- queued_jobs.Update
+UPDATE queued_jobs
+SET run_after = COALESCE($3, run_after),
+	expires_at = COALESCE($4, expires_at),
+	data = COALESCE($5, data),
+	updated_at = now()
+WHERE id = $1
+	AND name = $2
+	AND status = '%s'
+RETURNING %s`, models.StatusQueued, fields())
+	updateStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.RunNow
+UPDATE queued_jobs
+SET run_after = now(),
+	updated_at = now()
+WHERE name = $1
+	AND status = '%s'
+	AND run_after > now()`, models.StatusQueued)
+	runNowStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.CountReadyAndAll
 WITH all_count AS (
 	SELECT count(*) FROM queued_jobs
 ), ready_count AS (
-	SELECT count(*) FROM queued_jobs WHERE run_after <= now()
+	SELECT count(*) FROM queued_jobs WHERE run_after <= now() AND status <> '%s'
 ) 
 SELECT all_count.count, ready_count.count 
-FROM all_count, ready_count`
+FROM all_count, ready_count`, models.StatusBlocked)
 	countReadyAndAllStmt, err = db.Conn.Prepare(query)
 	if err != nil {
 		return err
 	}
 
+	query = fmt.Sprintf(`-- queued_jobs.CountReady
+SELECT count(*)
+FROM queued_jobs
+WHERE name = $1
+	AND status = '%s'
+	AND run_after <= now()
+	AND NOT EXISTS (
+		SELECT name
+		FROM jobs
+		WHERE jobs.name = $1
+			AND paused
+			AND (paused_until IS NULL OR paused_until > now())
+	)
+	AND NOT EXISTS (
+		SELECT name
+		FROM circuit_breakers
+		WHERE circuit_breakers.name = $1
+			AND state != '%s'
+			AND open_until > now()
+	)`, models.StatusQueued, models.CircuitClosed)
+	countReadyStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
 	query = `-- queued_jobs.GetCountsByStatus
 SELECT name, count(*) FROM queued_jobs WHERE status=$1 GROUP BY name`
 	countsByStatusStmt, err = db.Conn.Prepare(query)
@@ -148,30 +354,174 @@ SELECT %s FROM queued_jobs WHERE status='%s' AND updated_at < $1 LIMIT %d`,
 	if err != nil {
 		return err
 	}
+
+	query = fmt.Sprintf(`-- queued_jobs.GetDeadWorkerJobs
+SELECT %s
+FROM queued_jobs
+WHERE status = '%s'
+	AND worker_id IN (
+		SELECT id FROM workers WHERE heartbeat_at < $1
+	)
+LIMIT %d`, fields(), models.StatusInProgress, StuckJobLimit)
+	deadWorkerJobsStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- queued_jobs.AcquireExpired
+WITH expired AS (
+	SELECT id AS inner_id
+	FROM queued_jobs
+	WHERE status IN ('%[1]s', '%[2]s')
+		AND expires_at <= now()
+	ORDER BY expires_at ASC
+	LIMIT %[4]d
+	FOR UPDATE
+) UPDATE queued_jobs
+SET status = '%[3]s',
+	updated_at = now(),
+	debounce_key = NULL
+FROM expired
+WHERE queued_jobs.id = expired.inner_id
+	AND status IN ('%[1]s', '%[2]s')
+RETURNING %[5]s`, models.StatusQueued, models.StatusBlocked, models.StatusInProgress, ExpiredJobLimit, fields())
+	acquireExpiredStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
 	return
 }
 
+// EnqueueOptions holds the optional settings for a new job. The zero value
+// enqueues a plain job that can run as soon as runAfter has passed.
+type EnqueueOptions struct {
+	// If set, and a queued job with the same name and debounce key already
+	// exists, that job's data and expires_at are replaced and its run_after is
+	// pushed back, instead of creating a new job. In that case the returned
+	// QueuedJob has the ID of the existing job, not id. Jobs stop absorbing
+	// debounced enqueues once they've been acquired.
+	DebounceKey string
+	// If set, the job is created with the status "blocked" and can't run
+	// until Unblock is called.
+	DependsOn []types.PrefixUUID
+	// If set, the job is a member of the batch with the given id, which must
+	// exist and be open.
+	BatchID *types.PrefixUUID
+	// If set, links a follow-up job to the job that triggered it.
+	ParentID *types.PrefixUUID
+	// If set, the job won't be acquired while ConcurrencyLimit or more jobs
+	// with the same key are in progress.
+	ConcurrencyKey string
+	// Defaults to 1.
+	ConcurrencyLimit uint8
+	// If set, jobs with the same key are acquired one at a time, in the order
+	// they were enqueued.
+	OrderingKey string
+}
+
+// ErrDebounceConflict is returned when a debounce key is set along with
+// dependencies or a batch. A debounced enqueue is merged into whichever job
+// holds the key, which may not be blocked or in the batch.
+var ErrDebounceConflict = errors.New("Cannot set a debounce key along with dependencies or a batch id")
+
 // Enqueue creates a new queued job with the given ID and fields. A
 // dberror.Error will be returned if Postgres returns a constraint failure -
-// job exists, job name unknown, &c. A sql.ErrNoRows will be returned if the
-// `name` does not exist in the jobs table. Otherwise the QueuedJob will be
-// returned.
+// job exists, job name unknown, &c. An UnknownOrArchivedError will be
+// returned if the `name` does not exist in the jobs table or the job has
+// already been archived. Otherwise the QueuedJob will be returned.
 func Enqueue(id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage) (*models.QueuedJob, error) {
+	return EnqueueWithOptions(id, name, runAfter, expiresAt, data, EnqueueOptions{})
+}
+
+// EnqueueDebounced is like Enqueue, but if a queued job with the same name and
+// debounceKey already exists, that job is updated instead. See
+// EnqueueOptions.DebounceKey.
+func EnqueueDebounced(id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage, debounceKey string) (*models.QueuedJob, error) {
+	return EnqueueWithOptions(id, name, runAfter, expiresAt, data, EnqueueOptions{DebounceKey: debounceKey})
+}
+
+// EnqueueWithOptions is like Enqueue, with the optional settings in opts. An
+// UnknownOrArchivedError is also returned if opts.BatchID is set and the
+// batch doesn't exist or has been closed.
+func EnqueueWithOptions(id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage, opts EnqueueOptions) (*models.QueuedJob, error) {
+	return enqueue(nil, id, name, runAfter, expiresAt, data, opts)
+}
+
+// EnqueueTx is like EnqueueWithOptions, but runs inside the given transaction.
+func EnqueueTx(tx *sql.Tx, id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage, opts EnqueueOptions) (*models.QueuedJob, error) {
+	return enqueue(tx, id, name, runAfter, expiresAt, data, opts)
+}
+
+func enqueue(tx *sql.Tx, id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage, opts EnqueueOptions) (*models.QueuedJob, error) {
+	if opts.DebounceKey != "" && (len(opts.DependsOn) > 0 || opts.BatchID != nil) {
+		return nil, ErrDebounceConflict
+	}
+	// Only debounced jobs can be merged into an existing job.
+	stmt := enqueueStmt
+	if opts.DebounceKey != "" {
+		stmt = enqueueDebouncedStmt
+	}
+	if tx != nil {
+		stmt = tx.Stmt(stmt)
+	}
+	status := models.StatusQueued
+	if len(opts.DependsOn) > 0 {
+		status = models.StatusBlocked
+	}
+	// The vendored lib/pq can't encode arrays, so send a uuid[] literal.
+	parents := make([]string, len(opts.DependsOn))
+	for i, parent := range opts.DependsOn {
+		if parent.UUID == nil {
+			return nil, errors.New("Invalid parent id")
+		}
+		parents[i] = parent.UUID.String()
+	}
+	dependsOn := "{" + strings.Join(parents, ",") + "}"
+	batchID, err := uuidArg(opts.BatchID)
+	if err != nil {
+		return nil, err
+	}
+	parentID, err := uuidArg(opts.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	limit := opts.ConcurrencyLimit
+	if limit == 0 {
+		limit = 1
+	}
 	qj := new(models.QueuedJob)
 	// need to scan into a []byte, https://github.com/golang/go/issues/13905
 	var bt []byte
-	err := enqueueStmt.QueryRow(id, name, runAfter, expiresAt, []byte(data)).Scan(args(qj, &bt)...)
+	err = stmt.QueryRow(id, name, runAfter, expiresAt, []byte(data), status,
+		nullString(opts.DebounceKey), dependsOn, batchID, parentID,
+		nullString(opts.ConcurrencyKey), limit, nullString(opts.OrderingKey)).Scan(args(qj, &bt)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			e := &UnknownOrArchivedError{
-				Err: fmt.Sprintf("Job type %s does not exist or the job with that id has already been archived", name),
+			msg := fmt.Sprintf("Job type %s does not exist or the job with that id has already been archived", name)
+			if opts.BatchID != nil {
+				msg = fmt.Sprintf("Job type %s does not exist, batch %s is closed, or the job with that id has already been archived", name, opts.BatchID.String())
 			}
-			return nil, e
+			return nil, &UnknownOrArchivedError{Err: msg}
 		}
 		return nil, dberror.GetError(err)
 	}
 	qj.Data = json.RawMessage(bt)
-	return qj, err
+	return qj, nil
+}
+
+// uuidArg returns the query argument for an optional id column.
+func uuidArg(id *types.PrefixUUID) (interface{}, error) {
+	if id == nil {
+		return nil, nil
+	}
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	return *id, nil
+}
+
+func nullString(s string) types.NullString {
+	return types.NullString{Valid: s != "", String: s}
 }
 
 // Get the queued job with the given id. Returns the job, or an error. If no
@@ -208,10 +558,19 @@ func GetRetry(id types.PrefixUUID, attempts uint8) (job *models.QueuedJob, err e
 // Delete deletes the given queued job. Returns nil if the job was deleted
 // successfully. If no job exists to be deleted, sql.ErrNoRows is returned.
 func Delete(id types.PrefixUUID) error {
+	return deleteJob(deleteStmt, id)
+}
+
+// DeleteTx is like Delete, but runs inside the given transaction.
+func DeleteTx(tx *sql.Tx, id types.PrefixUUID) error {
+	return deleteJob(tx.Stmt(deleteStmt), id)
+}
+
+func deleteJob(stmt *sql.Stmt, id types.PrefixUUID) error {
 	if id.UUID == nil {
 		return errors.New("Invalid id")
 	}
-	res, err := deleteStmt.Exec(id)
+	res, err := stmt.Exec(id)
 	if err != nil {
 		return err
 	}
@@ -243,11 +602,106 @@ func DeleteRetry(id types.PrefixUUID, attempts uint8) error {
 // Acquire a queued job with the given name that's able to run now. Returns
 // the queued job and a boolean indicating whether the SELECT query found
 // a row, or a generic error/sql.ErrNoRows if no jobs are available.
+//
+// Jobs whose concurrency key already has ConcurrencyLimit jobs in progress are
+// skipped, as are jobs with an ordering key that aren't the oldest job with
+// that key. Older jobs block newer ones whatever their status or run_after,
+// so a job that's waiting to be retried holds up the rest of its key.
+//
+// No jobs are acquired while the job type is paused, or while its circuit
+// breaker is open.
 func Acquire(name string) (*models.QueuedJob, error) {
+	return AcquireWorker(name, nil)
+}
+
+// AcquireWorker is like Acquire, but records that the job is held by the
+// worker with the given id, if it's not nil.
+func AcquireWorker(name string, workerID *types.PrefixUUID) (*models.QueuedJob, error) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	qj, err := acquire(tx.Stmt(acquireStmt), name, workerID)
+	if err != nil {
+		return nil, err
+	}
+	if qj.ConcurrencyKey.Valid {
+		// Two dequeuers can acquire jobs with the same key at the same time,
+		// since neither can see the other's update. Lock the key until we
+		// commit, and count again once we hold the lock; a dequeuer waiting on
+		// the lock sees our job when it counts, and rolls its own back.
+		if _, err := tx.Stmt(lockConcurrencyKeyStmt).Exec(qj.ConcurrencyKey.String); err != nil {
+			return nil, dberror.GetError(err)
+		}
+		var count int64
+		err = tx.Stmt(countConcurrencyKeyStmt).QueryRow(qj.ConcurrencyKey.String).Scan(&count)
+		if err != nil {
+			return nil, dberror.GetError(err)
+		}
+		if count > int64(qj.ConcurrencyLimit) {
+			return nil, sql.ErrNoRows
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return qj, nil
+}
+
+// Release puts an in-progress job back in the queue without using up one of
+// its attempts, so another dequeuer can acquire it. Returns ErrNotFound if the
+// job isn't in progress.
+func Release(id types.PrefixUUID) error {
+	return release(id, types.NullTime{Valid: false})
+}
+
+// ReleaseAt is like Release, but the job can't be acquired again until
+// runAfter.
+func ReleaseAt(id types.PrefixUUID, runAfter time.Time) error {
+	return release(id, types.NullTime{Valid: true, Time: runAfter})
+}
+
+// Accepted records that the downstream server has accepted an in-progress
+// job, by clearing its worker. The job no longer depends on the dequeuer
+// process that sent it, so it isn't failed if that process dies; if the
+// downstream server never reports on it, it's failed once it's stuck. It's not
+// an error if the job has already finished.
+func Accepted(id types.PrefixUUID) error {
+	if id.UUID == nil {
+		return errors.New("Invalid id")
+	}
+	_, err := acceptedStmt.Exec(id)
+	return dberror.GetError(err)
+}
+
+func release(id types.PrefixUUID, runAfter types.NullTime) error {
+	if id.UUID == nil {
+		return errors.New("Invalid id")
+	}
+	res, err := releaseStmt.Exec(id, runAfter)
+	if err != nil {
+		return dberror.GetError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func acquire(stmt *sql.Stmt, name string, workerID *types.PrefixUUID) (*models.QueuedJob, error) {
 	qj := new(models.QueuedJob)
 	var bt []byte
 
-	rows, err := acquireStmt.Query(name)
+	var wid interface{}
+	if workerID != nil {
+		wid = *workerID
+	}
+	rows, err := stmt.Query(name, wid)
 	if err != nil {
 		err = dberror.GetError(err)
 		return nil, err
@@ -296,6 +750,123 @@ func Decrement(id types.PrefixUUID, attempts uint8, runAfter time.Time) (*models
 	return qj, nil
 }
 
+// Unblock marks a blocked job as queued, so it can be dequeued. Returns
+// ErrNotFound if the job doesn't exist or isn't blocked.
+func Unblock(id types.PrefixUUID) (*models.QueuedJob, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	qj := new(models.QueuedJob)
+	var bt []byte
+	err := unblockStmt.QueryRow(id).Scan(args(qj, &bt)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	qj.Data = json.RawMessage(bt)
+	return qj, nil
+}
+
+// GetBlockedDependents returns the blocked jobs that depend on the job with
+// the given id.
+func GetBlockedDependents(id types.PrefixUUID) ([]*models.QueuedJob, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	rows, err := getBlockedDependentsStmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*models.QueuedJob
+	for rows.Next() {
+		qj := new(models.QueuedJob)
+		var bt []byte
+		if err := rows.Scan(args(qj, &bt)...); err != nil {
+			return jobs, err
+		}
+		qj.Data = json.RawMessage(bt)
+		jobs = append(jobs, qj)
+	}
+	err = rows.Err()
+	return jobs, err
+}
+
+// GetResolvableBlockedJobs returns blocked jobs whose parents have all been
+// archived, so they can be unblocked or failed. Normally that happens as the
+// last parent is archived. A maximum of StuckJobLimit jobs will be returned.
+func GetResolvableBlockedJobs() ([]*models.QueuedJob, error) {
+	rows, err := getResolvableBlockedJobsStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*models.QueuedJob
+	for rows.Next() {
+		qj := new(models.QueuedJob)
+		var bt []byte
+		if err := rows.Scan(args(qj, &bt)...); err != nil {
+			return jobs, err
+		}
+		qj.Data = json.RawMessage(bt)
+		jobs = append(jobs, qj)
+	}
+	err = rows.Err()
+	return jobs, err
+}
+
+// Update changes the run_after, expires_at and data fields of a job that
+// hasn't been acquired yet. Pass an invalid NullTime or nil data to leave
+// that field unchanged.
+//
+// If the job has already been acquired by a dequeuer, ErrNotQueued is
+// returned, and if it's waiting on other jobs, ErrBlocked is returned. If no
+// job exists with that id and name, ErrNotFound is returned.
+func Update(id types.PrefixUUID, name string, runAfter types.NullTime, expiresAt types.NullTime, data json.RawMessage) (*models.QueuedJob, error) {
+	if id.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	// A nil []byte would be sent as an empty string, not NULL.
+	var dataArg interface{}
+	if data != nil {
+		dataArg = []byte(data)
+	}
+	qj := new(models.QueuedJob)
+	var bt []byte
+	err := updateStmt.QueryRow(id, name, runAfter, expiresAt, dataArg).Scan(args(qj, &bt)...)
+	if err == sql.ErrNoRows {
+		existing, getErr := Get(id)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if existing.Name != name {
+			return nil, ErrNotFound
+		}
+		if existing.Status == models.StatusBlocked {
+			return nil, ErrBlocked
+		}
+		return nil, ErrNotQueued
+	}
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	qj.Data = json.RawMessage(bt)
+	return qj, nil
+}
+
+// RunNow sets run_after to the current time for every queued job with the
+// given name that's scheduled to run in the future. Returns the number of
+// jobs that were updated.
+func RunNow(name string) (int64, error) {
+	res, err := runNowStmt.Exec(name)
+	if err != nil {
+		return 0, dberror.GetError(err)
+	}
+	return res.RowsAffected()
+}
+
 // GetOldInProgressJobs finds queued in-progress jobs with an updated_at
 // timestamp older than olderThan. A maximum of StuckJobLimit jobs will be
 // returned.
@@ -320,6 +891,51 @@ func GetOldInProgressJobs(olderThan time.Time) ([]*models.QueuedJob, error) {
 	return jobs, err
 }
 
+// GetDeadWorkerJobs finds in-progress jobs held by workers that haven't sent
+// a heartbeat since heartbeatBefore. A maximum of StuckJobLimit jobs will be
+// returned.
+func GetDeadWorkerJobs(heartbeatBefore time.Time) ([]*models.QueuedJob, error) {
+	rows, err := deadWorkerJobsStmt.Query(heartbeatBefore)
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	defer rows.Close()
+	var jobs []*models.QueuedJob
+	for rows.Next() {
+		qj := new(models.QueuedJob)
+		var bt []byte
+		if err := rows.Scan(args(qj, &bt)...); err != nil {
+			return jobs, err
+		}
+		qj.Data = json.RawMessage(bt)
+		jobs = append(jobs, qj)
+	}
+	return jobs, rows.Err()
+}
+
+// AcquireExpired marks up to ExpiredJobLimit queued or blocked jobs whose
+// expires_at has passed as in-progress, so no dequeuer can acquire them, and
+// returns them. The caller should archive the jobs as expired, or Release any
+// it can't archive.
+func AcquireExpired() ([]*models.QueuedJob, error) {
+	rows, err := acquireExpiredStmt.Query()
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	defer rows.Close()
+	var jobs []*models.QueuedJob
+	for rows.Next() {
+		qj := new(models.QueuedJob)
+		var bt []byte
+		if err := rows.Scan(args(qj, &bt)...); err != nil {
+			return jobs, err
+		}
+		qj.Data = json.RawMessage(bt)
+		jobs = append(jobs, qj)
+	}
+	return jobs, rows.Err()
+}
+
 // CountReadyAndAll returns the total number of queued and ready jobs in the
 // table.
 func CountReadyAndAll() (allCount int, readyCount int, err error) {
@@ -327,6 +943,14 @@ func CountReadyAndAll() (allCount int, readyCount int, err error) {
 	return
 }
 
+// CountReady returns the number of queued jobs with the given name that are
+// ready to run now. It's 0 while the job type is paused or its circuit is
+// open, since no jobs can be acquired.
+func CountReady(name string) (count int, err error) {
+	err = countReadyStmt.QueryRow(name).Scan(&count)
+	return
+}
+
 // GetCountsByStatus returns a map with each job type as the key, followed by
 // the number of <status> jobs it has. For example:
 //
@@ -371,7 +995,15 @@ func fields() string {
 	status,
 	data,
 	created_at,
-	updated_at`, Prefix)
+	updated_at,
+	debounce_key,
+	array_to_string(depends_on, ','),
+	'%s' || parent_id,
+	'%s' || batch_id,
+	concurrency_key,
+	concurrency_limit,
+	ordering_key,
+	'%s' || worker_id`, Prefix, Prefix, batches.Prefix, workers.Prefix)
 }
 
 func args(qj *models.QueuedJob, byteptr *[]byte) []interface{} {
@@ -386,5 +1018,44 @@ func args(qj *models.QueuedJob, byteptr *[]byte) []interface{} {
 		byteptr,
 		&qj.CreatedAt,
 		&qj.UpdatedAt,
+		&qj.DebounceKey,
+		(*uuidArray)(&qj.DependsOn),
+		&qj.ParentID,
+		&qj.BatchID,
+		&qj.ConcurrencyKey,
+		&qj.ConcurrencyLimit,
+		&qj.OrderingKey,
+		&qj.WorkerID,
 	}
 }
+
+// uuidArray scans a comma separated list of UUIDs into a slice of job IDs.
+type uuidArray []types.PrefixUUID
+
+// Scan implements the Scanner interface.
+func (a *uuidArray) Scan(src interface{}) error {
+	var str string
+	switch v := src.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return fmt.Errorf("Unsupported uuid array: %#v", src)
+	}
+	if str == "" {
+		*a = []types.PrefixUUID{}
+		return nil
+	}
+	strs := strings.Split(str, ",")
+	ids := make([]types.PrefixUUID, len(strs))
+	for i, str := range strs {
+		id, err := types.NewPrefixUUID(Prefix + str)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	*a = ids
+	return nil
+}
@@ -0,0 +1,92 @@
+// Logic for interacting with the "rate_limits" table.
+package rate_limits
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/rickover/models/db"
+)
+
+var takeTokenStmt *sql.Stmt
+var returnTokenStmt *sql.Stmt
+
+// Setup prepares all database statements.
+func Setup() (err error) {
+	if !db.Connected() {
+		return errors.New("No DB connection was established, can't query")
+	}
+
+	if takeTokenStmt != nil {
+		return
+	}
+
+	// Lock the bucket, refill it for the time since it was last updated, and
+	// take a token if there's one available. The bucket lives in its own
+	// table so taking a token doesn't lock the job type's row.
+	query := `-- rate_limits.TakeToken
+WITH bucket AS (
+	SELECT rate_limits.name,
+		jobs.rate_limit,
+		jobs.rate_interval_ms,
+		LEAST(jobs.rate_burst, rate_limits.tokens +
+			EXTRACT(EPOCH FROM now() - rate_limits.updated_at) * 1000 * jobs.rate_limit / jobs.rate_interval_ms
+		) AS available
+	FROM rate_limits
+	JOIN jobs ON jobs.name = rate_limits.name
+	WHERE rate_limits.name = $1
+		AND jobs.rate_limit > 0
+	FOR UPDATE OF rate_limits
+) UPDATE rate_limits
+SET tokens = CASE WHEN bucket.available >= 1 THEN bucket.available - 1 ELSE bucket.available END,
+	updated_at = now()
+FROM bucket
+WHERE rate_limits.name = bucket.name
+RETURNING bucket.available, bucket.rate_limit, bucket.rate_interval_ms`
+	takeTokenStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = `-- rate_limits.ReturnToken
+UPDATE rate_limits
+SET tokens = LEAST(tokens + 1, (SELECT rate_burst FROM jobs WHERE jobs.name = rate_limits.name))
+WHERE name = $1`
+	returnTokenStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	return
+}
+
+// TakeToken takes a token from the job type's rate limit bucket. If there
+// isn't a token available, ok is false, and wait is roughly how long it'll
+// take for the next token to arrive. Job types without a rate limit (and
+// unknown job types) always return ok.
+func TakeToken(name string) (ok bool, wait time.Duration, err error) {
+	var available float64
+	var limit, intervalMs uint32
+	err = takeTokenStmt.QueryRow(name).Scan(&available, &limit, &intervalMs)
+	if err == sql.ErrNoRows {
+		return true, 0, nil
+	}
+	if err != nil {
+		return false, 0, dberror.GetError(err)
+	}
+	if available >= 1 {
+		return true, 0, nil
+	}
+	msPerToken := float64(intervalMs) / float64(limit)
+	wait = time.Duration((1 - available) * msPerToken * float64(time.Millisecond))
+	return false, wait, nil
+}
+
+// ReturnToken puts back a token that was taken for a job that was never
+// sent, for example because there was no job to acquire. The bucket never
+// holds more than the job type's burst.
+func ReturnToken(name string) error {
+	_, err := returnTokenStmt.Exec(name)
+	return dberror.GetError(err)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Shyp/go-types"
+)
+
+// A Schedule enqueues a job each time its cron expression matches.
+type Schedule struct {
+	// Name uniquely identifies the schedule.
+	Name string `json:"name"`
+	// JobName is the job type to enqueue.
+	JobName string `json:"job_name"`
+	// Cron is a five-field cron expression, like "*/5 * * * *".
+	Cron string `json:"cron"`
+	// TimeZone is the IANA time zone the cron expression is evaluated in,
+	// like "America/Los_Angeles".
+	TimeZone string `json:"time_zone"`
+	// DataTemplate is a text/template that renders to the JSON data for each
+	// enqueued job.
+	DataTemplate string `json:"data_template"`
+	// NextRunAt is the next time the schedule will enqueue a job.
+	NextRunAt time.Time `json:"next_run_at"`
+	// MaxCatchUp is the most jobs to enqueue at once if the scheduler falls
+	// behind. Only the most recent missed ticks are enqueued; older ones are
+	// skipped. Defaults to 1, like cron.
+	MaxCatchUp int `json:"max_catch_up"`
+	// LastError is the most recent error rendering the data template, and
+	// LastErrorAt is when it happened. Ticks that can't be rendered are
+	// skipped.
+	LastError   types.NullString `json:"last_error"`
+	LastErrorAt types.NullTime   `json:"last_error_at"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
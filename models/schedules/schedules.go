@@ -0,0 +1,212 @@
+// Logic for interacting with the "schedules" table.
+package schedules
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+)
+
+// ErrNotFound indicates that the schedule was not found.
+var ErrNotFound = errors.New("Schedule not found")
+
+var createStmt *sql.Stmt
+var getStmt *sql.Stmt
+var deleteStmt *sql.Stmt
+var getDueStmt *sql.Stmt
+var advanceStmt *sql.Stmt
+var setErrorStmt *sql.Stmt
+
+// Setup prepares all database statements.
+func Setup() (err error) {
+	if !db.Connected() {
+		return errors.New("No DB connection was established, can't query")
+	}
+
+	if createStmt != nil {
+		return
+	}
+
+	query := fmt.Sprintf(`-- schedules.Create
+INSERT INTO schedules (name, job_name, cron, time_zone, data_template, next_run_at, max_catch_up)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (name) DO UPDATE
+SET job_name = excluded.job_name,
+	cron = excluded.cron,
+	time_zone = excluded.time_zone,
+	data_template = excluded.data_template,
+	next_run_at = excluded.next_run_at,
+	max_catch_up = excluded.max_catch_up,
+	last_error = NULL,
+	last_error_at = NULL,
+	updated_at = now()
+RETURNING %s`, fields())
+	createStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- schedules.Get
+SELECT %s
+FROM schedules
+WHERE name = $1`, fields())
+	getStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	deleteStmt, err = db.Conn.Prepare(`-- schedules.Delete
+DELETE FROM schedules WHERE name = $1`)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- schedules.GetDue
+SELECT %s
+FROM schedules
+WHERE next_run_at <= now()
+ORDER BY next_run_at
+LIMIT $1`, fields())
+	getDueStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	advanceStmt, err = db.Conn.Prepare(`-- schedules.Advance
+UPDATE schedules
+SET next_run_at = $3,
+	updated_at = now()
+WHERE name = $1
+	AND next_run_at = $2`)
+	if err != nil {
+		return err
+	}
+
+	setErrorStmt, err = db.Conn.Prepare(`-- schedules.SetError
+UPDATE schedules
+SET last_error = $2,
+	last_error_at = now(),
+	updated_at = now()
+WHERE name = $1`)
+	return
+}
+
+// Create creates a schedule, or replaces the schedule with the same name.
+// MaxCatchUp defaults to 1.
+func Create(s models.Schedule) (*models.Schedule, error) {
+	if s.MaxCatchUp == 0 {
+		s.MaxCatchUp = 1
+	}
+	dbSchedule := new(models.Schedule)
+	err := createStmt.QueryRow(s.Name, s.JobName, s.Cron, s.TimeZone, s.DataTemplate, s.NextRunAt, s.MaxCatchUp).Scan(args(dbSchedule)...)
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	return dbSchedule, nil
+}
+
+// Get returns the schedule with the given name, or ErrNotFound.
+func Get(name string) (*models.Schedule, error) {
+	s := new(models.Schedule)
+	err := getStmt.QueryRow(name).Scan(args(s)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	return s, nil
+}
+
+// Delete deletes the schedule with the given name. Jobs it has already
+// enqueued are not affected. Returns ErrNotFound if there's no schedule with
+// that name.
+func Delete(name string) error {
+	res, err := deleteStmt.Exec(name)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDue returns up to limit schedules whose next_run_at is in the past,
+// oldest first.
+func GetDue(limit int) ([]*models.Schedule, error) {
+	rows, err := getDueStmt.Query(limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var schedules []*models.Schedule
+	for rows.Next() {
+		s := new(models.Schedule)
+		if err := rows.Scan(args(s)...); err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, s)
+	}
+	err = rows.Err()
+	return schedules, err
+}
+
+// Advance moves the schedule's next_run_at from "from" to "to". It returns
+// false if next_run_at no longer equals from, which means another process has
+// already advanced the schedule.
+func Advance(name string, from time.Time, to time.Time) (bool, error) {
+	res, err := advanceStmt.Exec(name, from, to)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// SetError records an error processing the schedule.
+func SetError(name string, message string) error {
+	_, err := setErrorStmt.Exec(name, message)
+	return err
+}
+
+func fields() string {
+	return `name,
+job_name,
+cron,
+time_zone,
+data_template,
+next_run_at,
+max_catch_up,
+last_error,
+last_error_at,
+created_at,
+updated_at`
+}
+
+func args(s *models.Schedule) []interface{} {
+	return []interface{}{
+		&s.Name,
+		&s.JobName,
+		&s.Cron,
+		&s.TimeZone,
+		&s.DataTemplate,
+		&s.NextRunAt,
+		&s.MaxCatchUp,
+		&s.LastError,
+		&s.LastErrorAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Shyp/go-types"
+)
+
+// A Worker is a dequeuer process. Each process registers itself when it
+// starts, and heartbeats while it's running, so you can tell which processes
+// are alive and which jobs they're holding.
+type Worker struct {
+	ID       types.PrefixUUID `json:"id"`
+	Hostname string           `json:"hostname"`
+	Pid      int              `json:"pid"`
+	// Version is the rickover version the process is running.
+	Version string `json:"version"`
+	// JobTypes are the job types the process is dequeuing.
+	JobTypes []string `json:"job_types"`
+	// Concurrency is the total number of dequeuers in the process.
+	Concurrency int       `json:"concurrency"`
+	CreatedAt   time.Time `json:"created_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
@@ -0,0 +1,189 @@
+// Logic for interacting with the "workers" table.
+package workers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+)
+
+const Prefix = "worker_"
+
+// ErrNotFound indicates that the worker was not found.
+var ErrNotFound = errors.New("Worker not found")
+
+var heartbeatStmt *sql.Stmt
+var getAllStmt *sql.Stmt
+var deleteStmt *sql.Stmt
+var deleteDeadStmt *sql.Stmt
+
+// Setup prepares all database statements.
+func Setup() (err error) {
+	if !db.Connected() {
+		return errors.New("No DB connection was established, can't query")
+	}
+
+	if heartbeatStmt != nil {
+		return
+	}
+
+	query := fmt.Sprintf(`-- workers.Heartbeat
+INSERT INTO workers (id, hostname, pid, version, job_types, concurrency)
+VALUES ($1, $2, $3, $4, ARRAY(SELECT json_array_elements_text($5::json)), $6)
+ON CONFLICT (id) DO UPDATE
+SET job_types = excluded.job_types,
+	concurrency = excluded.concurrency,
+	heartbeat_at = now()
+RETURNING %s`, fields())
+	heartbeatStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`-- workers.GetAll
+SELECT %s
+FROM workers
+ORDER BY created_at ASC`, fields())
+	getAllStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	query = `-- workers.Delete
+DELETE FROM workers WHERE id = $1`
+	deleteStmt, err = db.Conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	// Keep dead workers around until the jobs they were holding have been
+	// handled, so the jobs can still be found.
+	query = fmt.Sprintf(`-- workers.DeleteDead
+DELETE FROM workers
+WHERE heartbeat_at < $1
+	AND NOT EXISTS (
+		SELECT id
+		FROM queued_jobs
+		WHERE queued_jobs.worker_id = workers.id
+			AND queued_jobs.status = '%s'
+	)`, models.StatusInProgress)
+	deleteDeadStmt, err = db.Conn.Prepare(query)
+	return
+}
+
+// Heartbeat records that the worker is alive, and updates the job types it's
+// dequeuing and its concurrency. The worker is registered if it doesn't exist
+// yet; its hostname, pid and version are only set then.
+func Heartbeat(w *models.Worker) (*models.Worker, error) {
+	if w.ID.UUID == nil {
+		return nil, errors.New("Invalid id")
+	}
+	jobTypes := w.JobTypes
+	if jobTypes == nil {
+		jobTypes = []string{}
+	}
+	jt, err := json.Marshal(jobTypes)
+	if err != nil {
+		return nil, err
+	}
+	return scan(heartbeatStmt.QueryRow(w.ID, w.Hostname, w.Pid, w.Version, string(jt), w.Concurrency))
+}
+
+// GetAll returns every registered worker, oldest first.
+func GetAll() ([]*models.Worker, error) {
+	rows, err := getAllStmt.Query()
+	if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	defer rows.Close()
+	workers := make([]*models.Worker, 0)
+	for rows.Next() {
+		w, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
+
+// Delete removes the worker with the given id, for example because the
+// process is shutting down. Returns ErrNotFound if there's no such worker.
+func Delete(id types.PrefixUUID) error {
+	if id.UUID == nil {
+		return errors.New("Invalid id")
+	}
+	res, err := deleteStmt.Exec(id)
+	if err != nil {
+		return dberror.GetError(err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteDead removes workers that haven't sent a heartbeat since
+// heartbeatBefore and aren't holding any in-progress jobs. Returns the number
+// of workers deleted.
+func DeleteDead(heartbeatBefore time.Time) (int64, error) {
+	res, err := deleteDeadStmt.Exec(heartbeatBefore)
+	if err != nil {
+		return 0, dberror.GetError(err)
+	}
+	return res.RowsAffected()
+}
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scan(row scanner) (*models.Worker, error) {
+	w := new(models.Worker)
+	var jobTypes []byte
+	err := row.Scan(args(w, &jobTypes)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, dberror.GetError(err)
+	}
+	if err := json.Unmarshal(jobTypes, &w.JobTypes); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func fields() string {
+	return fmt.Sprintf(`'%s' || id,
+hostname,
+pid,
+version,
+array_to_json(job_types),
+concurrency,
+created_at,
+heartbeat_at`, Prefix)
+}
+
+func args(w *models.Worker, jobTypes *[]byte) []interface{} {
+	return []interface{}{
+		&w.ID,
+		&w.Hostname,
+		&w.Pid,
+		&w.Version,
+		jobTypes,
+		&w.Concurrency,
+		&w.CreatedAt,
+		&w.HeartbeatAt,
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/dequeuer"
+)
+
+// GET /v1/pools
+var poolsRoute = regexp.MustCompile(`^/v1/pools$`)
+
+// GET /v1/pools/:name
+var poolRoute = regexp.MustCompile(`^/v1/pools/(?P<name>[^\s\/]+)$`)
+
+// POST/DELETE /v1/pools/:name/dequeuers
+var poolDequeuersRoute = regexp.MustCompile(`^/v1/pools/(?P<name>[^\s\/]+)/dequeuers$`)
+
+// POST /v1/pools/:name/pause
+var poolPauseRoute = regexp.MustCompile(`^/v1/pools/(?P<name>[^\s\/]+)/pause$`)
+
+// POST /v1/pools/:name/resume
+var poolResumeRoute = regexp.MustCompile(`^/v1/pools/(?P<name>[^\s\/]+)/resume$`)
+
+// Admin returns a http.Handler for inspecting and adjusting the dequeuer
+// pools running in this process. Dequeuers added through the API do their
+// work with w. Every route requires authentication with a.
+func Admin(pools dequeuer.Pools, w dequeuer.Worker, a Authorizer) http.Handler {
+	h := new(RegexpHandler)
+	h.Handler(poolsRoute, []string{"GET"}, authHandler(listPools(pools), a))
+	h.Handler(poolRoute, []string{"GET"}, authHandler(poolHandler(pools, poolRoute, nil), a))
+	h.Handler(poolDequeuersRoute, []string{"POST", "DELETE"}, authHandler(poolHandler(pools, poolDequeuersRoute, func(p *dequeuer.Pool, r *http.Request) error {
+		if r.Method == "DELETE" {
+			return p.RemoveDequeuer()
+		}
+		return p.AddDequeuer(w)
+	}), a))
+	h.Handler(poolPauseRoute, []string{"POST"}, authHandler(poolHandler(pools, poolPauseRoute, func(p *dequeuer.Pool, r *http.Request) error {
+		p.Pause()
+		return nil
+	}), a))
+	h.Handler(poolResumeRoute, []string{"POST"}, authHandler(poolHandler(pools, poolResumeRoute, func(p *dequeuer.Pool, r *http.Request) error {
+		p.Resume()
+		return nil
+	}), a))
+	return serverHeaderHandler(h)
+}
+
+// GET /v1/pools
+//
+// List the pools in this process, the number of dequeuers in each, and the
+// jobs they're working on.
+func listPools(pools dequeuer.Pools) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]dequeuer.PoolStatus, 0, len(pools))
+		for _, p := range pools {
+			if p != nil {
+				statuses = append(statuses, p.Status())
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			Pools []dequeuer.PoolStatus `json:"pools"`
+		}{statuses})
+	})
+}
+
+// poolHandler finds the pool named in the URL, calls action with it if
+// action is non-nil, and responds with the pool's status.
+func poolHandler(pools dequeuer.Pools, route *regexp.Regexp, action func(*dequeuer.Pool, *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := route.FindStringSubmatch(r.URL.Path)[1]
+		p := pools.Get(name)
+		if p == nil {
+			notFound(w, new404(r))
+			return
+		}
+		if action != nil {
+			if err := action(p, r); err != nil {
+				badRequest(w, r, &rest.Error{
+					ID:       "invalid_request",
+					Title:    err.Error(),
+					Instance: r.URL.Path,
+				})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(p.Status())
+	})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rickover/dequeuer"
+	"github.com/Shyp/rickover/test"
+)
+
+func adminRequest(t *testing.T, pools dequeuer.Pools, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.SetBasicAuth("foo", "bar")
+	Admin(pools, nil, u).ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminListPools(t *testing.T) {
+	t.Parallel()
+	pools := dequeuer.Pools{dequeuer.NewPool("echo"), dequeuer.NewPool("render-pdf")}
+	w := adminRequest(t, pools, "GET", "/v1/pools")
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	var body struct {
+		Pools []dequeuer.PoolStatus `json:"pools"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(body.Pools), 2)
+	test.AssertEquals(t, body.Pools[1].Name, "render-pdf")
+	test.AssertEquals(t, body.Pools[1].Dequeuers, 0)
+	test.AssertEquals(t, len(body.Pools[1].InFlight), 0)
+}
+
+func TestAdminPausePool(t *testing.T) {
+	t.Parallel()
+	p := dequeuer.NewPool("echo")
+	pools := dequeuer.Pools{p}
+	w := adminRequest(t, pools, "POST", "/v1/pools/echo/pause")
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	var status dequeuer.PoolStatus
+	err := json.NewDecoder(w.Body).Decode(&status)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, status.Paused, true)
+	test.AssertEquals(t, p.Paused(), true)
+
+	w = adminRequest(t, pools, "POST", "/v1/pools/echo/resume")
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	test.AssertEquals(t, p.Paused(), false)
+}
+
+func TestAdminRemoveDequeuerEmptyPool(t *testing.T) {
+	t.Parallel()
+	pools := dequeuer.Pools{dequeuer.NewPool("echo")}
+	w := adminRequest(t, pools, "DELETE", "/v1/pools/echo/dequeuers")
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+}
+
+func TestAdminUnknownPool(t *testing.T) {
+	t.Parallel()
+	w := adminRequest(t, dequeuer.Pools{}, "GET", "/v1/pools/unknown")
+	test.AssertEquals(t, w.Code, http.StatusNotFound)
+}
+
+func TestAdminRequiresAuth(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/pools", nil)
+	Admin(dequeuer.Pools{}, nil, u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusUnauthorized)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
+	"github.com/Shyp/rickover/services"
+)
+
+// A CreateBatchRequest is sent in the body of a request to POST /v1/batches.
+type CreateBatchRequest struct {
+	// The job type to enqueue once the batch is closed and every job in it has
+	// been archived. Optional.
+	OnComplete string `json:"on_complete"`
+	// Data to pass to the on_complete job. Defaults to {}.
+	Data json.RawMessage `json:"data"`
+}
+
+// A BatchResponse is returned from the batch endpoints.
+type BatchResponse struct {
+	*models.Batch
+	// Status is "open" if jobs can still be added to the batch, "closed" if
+	// it's closed but some of its jobs haven't finished, or "complete".
+	Status string `json:"status"`
+	// Total is the number of jobs in the batch.
+	Total int64 `json:"total"`
+	// Counts is the number of jobs in the batch with each status.
+	Counts map[models.JobStatus]int64 `json:"counts"`
+}
+
+func writeBatch(w http.ResponseWriter, r *http.Request, code int, b *models.Batch) {
+	counts, err := batches.GetCounts(b.ID)
+	if err != nil {
+		writeServerError(w, r, err)
+		return
+	}
+	resp := BatchResponse{Batch: b, Status: "open", Counts: counts}
+	if b.CompletedAt.Valid {
+		resp.Status = "complete"
+	} else if b.ClosedAt.Valid {
+		resp.Status = "closed"
+	}
+	for _, count := range counts {
+		resp.Total += count
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /v1/batches
+//
+// Create a new batch.
+func createBatch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cbr CreateBatchRequest
+		if r.Body != nil {
+			defer r.Body.Close()
+			err := json.NewDecoder(r.Body).Decode(&cbr)
+			if err != nil {
+				badRequest(w, r, &rest.Error{
+					ID:    "invalid_request",
+					Title: "Invalid request: bad JSON. Double check the types of the fields you sent",
+				})
+				return
+			}
+		}
+		if len(cbr.Data) > MAX_ENQUEUE_DATA_SIZE {
+			err := &rest.Error{
+				ID:    "entity_too_large",
+				Title: "Data parameter is too large (100KB max)",
+			}
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(err)
+			return
+		}
+		id, err := types.GenerateUUID(batches.Prefix)
+		if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		b, err := batches.Create(models.Batch{
+			ID:         id,
+			OnComplete: types.NullString{Valid: cbr.OnComplete != "", String: cbr.OnComplete},
+			Data:       cbr.Data,
+		})
+		if err != nil {
+			switch terr := err.(type) {
+			case *dberror.Error:
+				if terr.Code == dberror.CodeForeignKeyViolation {
+					notFound(w, &rest.Error{
+						Title:    fmt.Sprintf("Job type %s not found", cbr.OnComplete),
+						ID:       "job_type_not_found",
+						Instance: fmt.Sprintf("/v1/jobs/%s", cbr.OnComplete),
+					})
+					return
+				}
+				badRequest(w, r, &rest.Error{
+					Title:    terr.Message,
+					ID:       "invalid_parameter",
+					Instance: r.URL.Path,
+				})
+				return
+			default:
+				writeServerError(w, r, err)
+				return
+			}
+		}
+		writeBatch(w, r, http.StatusCreated, b)
+		go metrics.Increment("batch.create.success")
+	})
+}
+
+// GET /v1/batches/:id
+//
+// Retrieve a batch, along with the number of jobs in it with each status.
+func getBatch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, wroteResponse := getPrefixedId(w, r, batchRoute.FindStringSubmatch(r.URL.Path)[1], batches.Prefix)
+		if wroteResponse == true {
+			return
+		}
+		b, err := batches.Get(id)
+		if err == batches.ErrNotFound {
+			notFound(w, new404(r))
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		writeBatch(w, r, http.StatusOK, b)
+	})
+}
+
+// POST /v1/batches/:id/close
+//
+// Close a batch, so no more jobs can be added to it.
+func closeBatch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, wroteResponse := getPrefixedId(w, r, closeBatchRoute.FindStringSubmatch(r.URL.Path)[1], batches.Prefix)
+		if wroteResponse == true {
+			return
+		}
+		b, err := services.CloseBatch(id)
+		if err == batches.ErrNotFound {
+			notFound(w, new404(r))
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err)
+			go metrics.Increment("batch.close.error")
+			return
+		}
+		writeBatch(w, r, http.StatusOK, b)
+	})
+}
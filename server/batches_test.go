@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/test"
+)
+
+func Test400BatchWrongPrefix(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/batches/batch_6740b44e-13b9-475d-af06", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.ID, "invalid_uuid")
+}
+
+func Test405CloseBatch(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/batches/batch_6740b44e-13b9-475d-af06-979627e0e0d6/close", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusMethodNotAllowed)
+}
+
+func Test400EnqueueBatchIDWrongPrefix(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}, "batch_id": "job_4a6fe9c6-02b5-4e46-90b4-4a3a8a1e5a8e"}`)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.ID, "invalid_prefix")
+}
+
+func Test400EnqueueBatchIDWithDebounce(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}, "debounce_key": "usr_123", "debounce": "30s", "batch_id": "batch_4a6fe9c6-02b5-4e46-90b4-4a3a8a1e5a8e"}`)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Cannot set both batch_id and debounce_key")
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/circuit_breakers"
+	"github.com/Shyp/rickover/models/jobs"
+)
+
+// GET /v1/jobs/:name/circuit
+//
+// Get the state of a job type's circuit breaker. Job types that have never
+// failed are reported as closed.
+func getCircuitBreaker() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := circuitRoute.FindStringSubmatch(r.URL.Path)[1]
+		job, err := jobs.Get(name)
+		if err == sql.ErrNoRows {
+			notFound(w, new404(r))
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		cb, err := circuit_breakers.Get(name)
+		if err == circuit_breakers.ErrNotFound {
+			cb = &models.CircuitBreaker{
+				Name:      job.Name,
+				State:     models.CircuitClosed,
+				UpdatedAt: job.CreatedAt,
+			}
+		} else if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(cb)
+	})
+}
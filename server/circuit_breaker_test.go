@@ -0,0 +1,18 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rickover/test"
+)
+
+func Test405CircuitBreaker(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/jobs/echo/circuit", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusMethodNotAllowed)
+}
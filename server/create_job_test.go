@@ -214,3 +214,91 @@ func Test401SetsToken(t *testing.T) {
 	test.AssertEquals(t, f.UserId, "usr_123")
 	test.AssertEquals(t, f.Token, "tok_123")
 }
+
+func Test400FollowUpIsSelf(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := new(bytes.Buffer)
+	body := validRequest
+	body.OnFailure = body.Name
+	json.NewEncoder(b).Encode(body)
+	req, err := http.NewRequest("POST", "/v1/jobs", b)
+	test.AssertNotError(t, err, "")
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "A job type cannot enqueue itself as a follow-up job")
+}
+
+func Test400OnExpireIsSelf(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := new(bytes.Buffer)
+	body := validRequest
+	body.OnExpire = body.Name
+	json.NewEncoder(b).Encode(body)
+	req, err := http.NewRequest("POST", "/v1/jobs", b)
+	test.AssertNotError(t, err, "")
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+}
+
+func Test400RateBurstWithoutRateLimit(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := new(bytes.Buffer)
+	body := validRequest
+	body.RateBurst = 5
+	json.NewEncoder(b).Encode(body)
+	req, err := http.NewRequest("POST", "/v1/jobs", b)
+	test.AssertNotError(t, err, "")
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Missing required field: rate_limit")
+}
+
+func Test400MinConcurrencyWithoutMax(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := new(bytes.Buffer)
+	body := validRequest
+	body.MinConcurrency = 1
+	json.NewEncoder(b).Encode(body)
+	req, err := http.NewRequest("POST", "/v1/jobs", b)
+	test.AssertNotError(t, err, "")
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Missing required field: max_concurrency")
+}
+
+func Test400ConcurrencyOutsideAutoscaleRange(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := new(bytes.Buffer)
+	body := validRequest
+	body.Concurrency = 10
+	body.MinConcurrency = 1
+	body.MaxConcurrency = 5
+	json.NewEncoder(b).Encode(body)
+	req, err := http.NewRequest("POST", "/v1/jobs", b)
+	test.AssertNotError(t, err, "")
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Concurrency must be between min_concurrency and max_concurrency")
+}
@@ -181,3 +181,106 @@ func Test413TooLargeJSON(t *testing.T) {
 	test.AssertEquals(t, e.Title, "Data parameter is too large (100KB max)")
 	test.AssertEquals(t, e.ID, "entity_too_large")
 }
+
+func Test400DebounceWithoutKey(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	ejr := &EnqueueJobRequest{
+		Data:     empty,
+		Debounce: "30s",
+	}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(ejr)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("test", "password")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Missing required field: debounce_key")
+	test.AssertEquals(t, e.ID, "missing_parameter")
+}
+
+func Test400InvalidDebounce(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	ejr := &EnqueueJobRequest{
+		Data:        empty,
+		DebounceKey: "usr_123",
+		Debounce:    "thirty seconds",
+	}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(ejr)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("test", "password")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Invalid debounce duration: thirty seconds")
+	test.AssertEquals(t, e.ID, "invalid_parameter")
+}
+
+func Test400ZeroDebounce(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	ejr := &EnqueueJobRequest{
+		Data:        empty,
+		DebounceKey: "usr_123",
+		Debounce:    "0s",
+	}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(ejr)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("test", "password")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Invalid debounce duration: 0s")
+}
+
+func Test400DependsOnSelf(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}, "depends_on": ["job_6740b44e-13b9-475d-af06-979627e0e0d6"]}`)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "A job cannot depend on itself")
+}
+
+func Test400DependsOnWithDebounce(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}, "debounce_key": "usr_123", "debounce": "30s", "depends_on": ["job_4a6fe9c6-02b5-4e46-90b4-4a3a8a1e5a8e"]}`)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Cannot set both depends_on and debounce_key")
+}
+
+func Test400ConcurrencyLimitWithoutKey(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}, "concurrency_limit": 2}`)
+	req, _ := http.NewRequest("PUT", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Missing required field: concurrency_key")
+}
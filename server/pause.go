@@ -0,0 +1,83 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/jobs"
+)
+
+// A PauseJobRequest can be sent in the body of a request to POST
+// /v1/jobs/:name/pause.
+type PauseJobRequest struct {
+	// If set, the job type resumes automatically at this time. Otherwise it
+	// stays paused until you resume it.
+	PausedUntil types.NullTime `json:"paused_until"`
+}
+
+func writePausedJob(w http.ResponseWriter, r *http.Request, job *models.Job, err error, metricName string) {
+	if err == sql.ErrNoRows {
+		notFound(w, new404(r))
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err)
+		go metrics.Increment(fmt.Sprintf("%s.error", metricName))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+	go metrics.Increment(fmt.Sprintf("%s.success", metricName))
+}
+
+// POST /v1/jobs/:name/pause
+//
+// Stop dequeuers from acquiring jobs of the given type. Queued jobs are kept,
+// and new jobs can still be enqueued.
+func pauseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pauseRoute.FindStringSubmatch(r.URL.Path)[1]
+		var pjr PauseJobRequest
+		if r.Body != nil {
+			defer r.Body.Close()
+			err := json.NewDecoder(r.Body).Decode(&pjr)
+			// An empty body is fine, it pauses the job type indefinitely.
+			if err != nil && err != io.EOF {
+				badRequest(w, r, &rest.Error{
+					ID:    "invalid_request",
+					Title: "Invalid request: bad JSON. Double check the types of the fields you sent",
+				})
+				return
+			}
+		}
+		if pjr.PausedUntil.Valid && !pjr.PausedUntil.Time.After(time.Now()) {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_parameter",
+				Title:    "paused_until must be in the future",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		job, err := jobs.Pause(name, pjr.PausedUntil)
+		writePausedJob(w, r, job, err, "job.pause")
+	})
+}
+
+// POST /v1/jobs/:name/resume
+//
+// Let dequeuers acquire jobs of the given type again.
+func resumeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := resumeRoute.FindStringSubmatch(r.URL.Path)[1]
+		job, err := jobs.Resume(name)
+		writePausedJob(w, r, job, err, "job.resume")
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/test"
+)
+
+func Test400PausedUntilInPast(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"paused_until": "2016-01-01T00:00:00Z"}`)
+	req, _ := http.NewRequest("POST", "/v1/jobs/echo/pause", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "paused_until must be in the future")
+}
+
+func Test405Resume(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/jobs/echo/resume", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusMethodNotAllowed)
+}
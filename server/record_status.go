@@ -27,6 +27,10 @@ type JobStatusRequest struct {
 	// Retryable indicates whether a failure is retryable. The default is true.
 	// Set to false to avoid retrying a particular failure.
 	Retryable *bool `json:"retryable"` // pointer to distinguish between null value and false.
+
+	// Result is optional JSON describing the outcome of the job. It's passed
+	// to the job type's on_success or on_failure follow-up job, if any.
+	Result json.RawMessage `json:"result"`
 }
 
 // POST /v1/jobs/:name/:id
@@ -73,7 +77,16 @@ func (j *jobStatusUpdater) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// http://stackoverflow.com/q/30716354/329700
 		jsr.Retryable = func() *bool { b := true; return &b }()
 	}
-	err = services.HandleStatusCallback(id, name, jsr.Status, *jsr.Attempt, *jsr.Retryable)
+	if len(jsr.Result) > MAX_ENQUEUE_DATA_SIZE {
+		err := &rest.Error{
+			ID:    "entity_too_large",
+			Title: "Result parameter is too large (100KB max)",
+		}
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(err)
+		return
+	}
+	err = services.HandleStatusCallbackResult(id, name, jsr.Status, *jsr.Attempt, *jsr.Retryable, jsr.Result)
 	if err == nil {
 		w.WriteHeader(http.StatusOK)
 	} else if err == queued_jobs.ErrNotFound {
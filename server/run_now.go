@@ -0,0 +1,63 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models/jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// A RunNowResponse is returned from POST /v1/jobs/:name/run-now.
+type RunNowResponse struct {
+	// The number of delayed jobs that were moved up to run immediately.
+	Count int64 `json:"count"`
+}
+
+// POST /v1/jobs/:name/:id/run-now
+//
+// Set run_after to the current time for a job that hasn't been acquired yet,
+// so the next available dequeuer will pick it up. Unlike a replay, the job
+// keeps its ID.
+func runNowHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := runNowRoute.FindStringSubmatch(r.URL.Path)
+		name := match[1]
+		id, wroteResponse := getId(w, r, match[2])
+		if wroteResponse == true {
+			return
+		}
+		runAfter := types.NullTime{Valid: true, Time: time.Now().UTC()}
+		updateQueuedJob(w, r, id, name, runAfter, types.NullTime{Valid: false}, nil, "job.run_now")
+	})
+}
+
+// POST /v1/jobs/:name/run-now
+//
+// Set run_after to the current time for every delayed job of the given type.
+func runAllNowHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := runAllNowRoute.FindStringSubmatch(r.URL.Path)[1]
+		if _, err := jobs.Get(name); err != nil {
+			if err == sql.ErrNoRows {
+				notFound(w, new404(r))
+				return
+			}
+			writeServerError(w, r, err)
+			return
+		}
+		count, err := queued_jobs.RunNow(name)
+		if err != nil {
+			writeServerError(w, r, err)
+			go metrics.Increment("job.run_now.bulk.error")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(RunNowResponse{Count: count})
+		go metrics.Increment("job.run_now.bulk.success")
+	})
+}
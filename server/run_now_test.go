@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rickover/test"
+)
+
+func Test400RunNowInvalidID(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/jobs/echo/job_foo/run-now", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+}
+
+func Test405RunNowGet(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/jobs/echo/run-now", nil)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusMethodNotAllowed)
+}
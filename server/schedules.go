@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/cron"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/schedules"
+	"github.com/Shyp/rickover/services"
+)
+
+// A CreateScheduleRequest is sent in the body of a request to POST
+// /v1/schedules.
+type CreateScheduleRequest struct {
+	// Name uniquely identifies the schedule. Sending a request with the name
+	// of an existing schedule replaces it.
+	Name string `json:"name"`
+	// The job type to enqueue.
+	JobName string `json:"job_name"`
+	// A five-field cron expression, like "0 9 * * mon-fri".
+	Cron string `json:"cron"`
+	// The IANA time zone to evaluate the cron expression in. Defaults to UTC.
+	TimeZone string `json:"time_zone"`
+	// A text/template that renders the JSON data for each job. Defaults to
+	// "{}".
+	DataTemplate string `json:"data_template"`
+	// The most jobs to enqueue at once if the scheduler falls behind.
+	// Defaults to 1.
+	MaxCatchUp int `json:"max_catch_up"`
+}
+
+// POST /v1/schedules
+//
+// Create or replace a schedule.
+func createSchedule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			badRequest(w, r, createEmptyErr("name", r.URL.Path))
+			return
+		}
+		defer r.Body.Close()
+		var csr CreateScheduleRequest
+		err := json.NewDecoder(r.Body).Decode(&csr)
+		if err != nil {
+			badRequest(w, r, &rest.Error{
+				ID:    "invalid_request",
+				Title: "Invalid request: bad JSON. Double check the types of the fields you sent",
+			})
+			return
+		}
+		if csr.Name == "" {
+			badRequest(w, r, createEmptyErr("name", r.URL.Path))
+			return
+		}
+		if csr.JobName == "" {
+			badRequest(w, r, createEmptyErr("job_name", r.URL.Path))
+			return
+		}
+		if csr.Cron == "" {
+			badRequest(w, r, createEmptyErr("cron", r.URL.Path))
+			return
+		}
+		if csr.TimeZone == "" {
+			csr.TimeZone = "UTC"
+		}
+		if csr.DataTemplate == "" {
+			csr.DataTemplate = "{}"
+		}
+		if csr.MaxCatchUp < 0 {
+			badRequest(w, r, createPositiveIntErr("MaxCatchUp", r.URL.Path))
+			return
+		}
+		if _, err := cron.Parse(csr.Cron); err != nil {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_cron",
+				Title:    fmt.Sprintf("Invalid cron expression: %s", csr.Cron),
+				Detail:   err.Error(),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		if _, err := time.LoadLocation(csr.TimeZone); err != nil {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_time_zone",
+				Title:    fmt.Sprintf("Invalid time zone: %s", csr.TimeZone),
+				Detail:   "Time zones should be names from the IANA database, like \"America/Los_Angeles\".",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		nextRunAt, err := services.NextScheduledRun(csr.Cron, csr.TimeZone, time.Now())
+		if err != nil {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_cron",
+				Title:    err.Error(),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		sched := models.Schedule{
+			Name:         csr.Name,
+			JobName:      csr.JobName,
+			Cron:         csr.Cron,
+			TimeZone:     csr.TimeZone,
+			DataTemplate: csr.DataTemplate,
+			NextRunAt:    nextRunAt,
+			MaxCatchUp:   csr.MaxCatchUp,
+		}
+		if _, err := services.RenderScheduleData(&sched, nextRunAt); err != nil {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_data_template",
+				Title:    "Invalid data template",
+				Detail:   err.Error(),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+
+		s, err := schedules.Create(sched)
+		if err != nil {
+			switch terr := err.(type) {
+			case *dberror.Error:
+				if terr.Code == dberror.CodeForeignKeyViolation {
+					notFound(w, &rest.Error{
+						Title:    fmt.Sprintf("Job type %s not found", csr.JobName),
+						ID:       "job_type_not_found",
+						Instance: fmt.Sprintf("/v1/jobs/%s", csr.JobName),
+					})
+					return
+				}
+				badRequest(w, r, &rest.Error{
+					Title:    terr.Message,
+					ID:       "invalid_parameter",
+					Instance: r.URL.Path,
+				})
+				return
+			default:
+				writeServerError(w, r, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+		go metrics.Increment("schedule.create.success")
+	})
+}
+
+// GET/DELETE /v1/schedules/:name
+//
+// Retrieve or delete a schedule.
+func handleScheduleRoute() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := scheduleRoute.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == "DELETE" {
+			err := schedules.Delete(name)
+			if err == schedules.ErrNotFound {
+				notFound(w, new404(r))
+				return
+			}
+			if err != nil {
+				writeServerError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			go metrics.Increment("schedule.delete.success")
+			return
+		}
+		s, err := schedules.Get(name)
+		if err == schedules.ErrNotFound {
+			notFound(w, new404(r))
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(s)
+	})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/test"
+)
+
+var invalidScheduleTests = []struct {
+	req CreateScheduleRequest
+	id  string
+}{
+	{CreateScheduleRequest{JobName: "echo", Cron: "* * * * *"}, "missing_parameter"},
+	{CreateScheduleRequest{Name: "nightly", Cron: "* * * * *"}, "missing_parameter"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo"}, "missing_parameter"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo", Cron: "61 * * * *"}, "invalid_cron"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo", Cron: "0 0 30 2 *"}, "invalid_cron"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo", Cron: "* * * * *", TimeZone: "Mars/Olympus_Mons"}, "invalid_time_zone"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo", Cron: "* * * * *", DataTemplate: "{{.Unknown}}"}, "invalid_data_template"},
+	{CreateScheduleRequest{Name: "nightly", JobName: "echo", Cron: "* * * * *", DataTemplate: "not json"}, "invalid_data_template"},
+}
+
+func Test400InvalidSchedule(t *testing.T) {
+	t.Parallel()
+	for _, tt := range invalidScheduleTests {
+		w := httptest.NewRecorder()
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(tt.req)
+		req, _ := http.NewRequest("POST", "/v1/schedules", b)
+		req.SetBasicAuth("foo", "bar")
+		Get(u).ServeHTTP(w, req)
+		test.AssertEquals(t, w.Code, http.StatusBadRequest)
+		var e rest.Error
+		err := json.Unmarshal(w.Body.Bytes(), &e)
+		test.AssertNotError(t, err, "")
+		test.AssertEquals(t, e.ID, tt.id)
+	}
+}
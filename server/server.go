@@ -23,8 +23,10 @@ import (
 	"github.com/Shyp/rickover/config"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/batches"
 	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
 )
 
 // TODO(burke) use http.LimitedBytesReader.
@@ -41,6 +43,39 @@ var DefaultServer http.Handler
 // POST /v1/jobs(/:name)/:id/replay
 var replayRoute = regexp.MustCompile(`^/v1/jobs(/(?P<JobName>[^\s\/]+))?/(?P<id>job_[^\s\/]+)/replay$`)
 
+// POST /v1/jobs/:name/:id/run-now
+var runNowRoute = regexp.MustCompile(`^/v1/jobs/(?P<JobName>[^\s\/]+)/(?P<id>job_[^\s\/]+)/run-now$`)
+
+// POST /v1/jobs/:name/run-now
+var runAllNowRoute = regexp.MustCompile(`^/v1/jobs/(?P<JobName>[^\s\/]+)/run-now$`)
+
+// POST /v1/jobs/:name/pause
+var pauseRoute = regexp.MustCompile(`^/v1/jobs/(?P<JobName>[^\s\/]+)/pause$`)
+
+// POST /v1/jobs/:name/resume
+var resumeRoute = regexp.MustCompile(`^/v1/jobs/(?P<JobName>[^\s\/]+)/resume$`)
+
+// GET /v1/jobs/:name/circuit
+var circuitRoute = regexp.MustCompile(`^/v1/jobs/(?P<JobName>[^\s\/]+)/circuit$`)
+
+// POST /v1/schedules
+var schedulesRoute = regexp.MustCompile(`^/v1/schedules$`)
+
+// GET/DELETE /v1/schedules/:name
+var scheduleRoute = regexp.MustCompile(`^/v1/schedules/(?P<name>[^\s\/]+)$`)
+
+// POST /v1/batches
+var batchesRoute = regexp.MustCompile(`^/v1/batches$`)
+
+// GET /v1/batches/:id
+var batchRoute = regexp.MustCompile(`^/v1/batches/(?P<id>batch_[^\s\/]+)$`)
+
+// POST /v1/batches/:id/close
+var closeBatchRoute = regexp.MustCompile(`^/v1/batches/(?P<id>batch_[^\s\/]+)/close$`)
+
+// GET /v1/workers
+var workersRoute = regexp.MustCompile(`^/v1/workers$`)
+
 // GET /v1/jobs/job_123
 //
 // Must go before the getJobTypeRoute
@@ -64,9 +99,23 @@ func Get(a Authorizer) http.Handler {
 	h.Handler(getJobRoute, []string{"GET"}, authHandler(handleJobRoute(), a))
 	h.Handler(getJobTypeRoute, []string{"GET"}, authHandler(getJobType(), a))
 
-	h.Handler(jobIdRoute, []string{"GET", "POST", "PUT"}, authHandler(handleJobRoute(), a))
+	h.Handler(jobIdRoute, []string{"GET", "POST", "PUT", "PATCH"}, authHandler(handleJobRoute(), a))
 
 	h.Handler(replayRoute, []string{"POST"}, authHandler(replayHandler(), a))
+	h.Handler(runNowRoute, []string{"POST"}, authHandler(runNowHandler(), a))
+	h.Handler(runAllNowRoute, []string{"POST"}, authHandler(runAllNowHandler(), a))
+	h.Handler(pauseRoute, []string{"POST"}, authHandler(pauseHandler(), a))
+	h.Handler(resumeRoute, []string{"POST"}, authHandler(resumeHandler(), a))
+	h.Handler(circuitRoute, []string{"GET"}, authHandler(getCircuitBreaker(), a))
+
+	h.Handler(schedulesRoute, []string{"POST"}, authHandler(createSchedule(), a))
+	h.Handler(scheduleRoute, []string{"GET", "DELETE"}, authHandler(handleScheduleRoute(), a))
+
+	h.Handler(batchesRoute, []string{"POST"}, authHandler(createBatch(), a))
+	h.Handler(batchRoute, []string{"GET"}, authHandler(getBatch(), a))
+	h.Handler(closeBatchRoute, []string{"POST"}, authHandler(closeBatch(), a))
+
+	h.Handler(workersRoute, []string{"GET"}, authHandler(listWorkers(), a))
 
 	h.Handler(regexp.MustCompile("^/debug/pprof$"), []string{"GET"}, authHandler(http.HandlerFunc(pprof.Index), a))
 	h.Handler(regexp.MustCompile("^/debug/pprof/cmdline$"), []string{"GET"}, authHandler(http.HandlerFunc(pprof.Cmdline), a))
@@ -182,6 +231,27 @@ type CreateJobRequest struct {
 	Attempts         uint8                   `json:"attempts"`
 	Concurrency      uint8                   `json:"concurrency"`
 	DeliveryStrategy models.DeliveryStrategy `json:"delivery_strategy"`
+	// The name of a job type to enqueue after a job of this type succeeds.
+	OnSuccess string `json:"on_success"`
+	// The name of a job type to enqueue after a job of this type fails.
+	OnFailure string `json:"on_failure"`
+	// The name of a job type to enqueue after a job of this type expires.
+	OnExpire string `json:"on_expire"`
+	// The number of jobs that can be sent downstream per RateIntervalMs,
+	// across all dequeuers. Zero or omitted means no limit.
+	RateLimit uint32 `json:"rate_limit"`
+	// Defaults to 1000 (one second).
+	RateIntervalMs uint32 `json:"rate_interval_ms"`
+	// The number of jobs that can be sent at once after the job type has been
+	// idle. Defaults to RateLimit.
+	RateBurst uint32 `json:"rate_burst"`
+	// If true, the downstream server can respond to a job with a 200 and the
+	// job's status, instead of making a callback.
+	Synchronous bool `json:"synchronous"`
+	// If MaxConcurrency is set, dequeuers scale the number of dequeuers
+	// between MinConcurrency and MaxConcurrency, starting at Concurrency.
+	MinConcurrency uint8 `json:"min_concurrency"`
+	MaxConcurrency uint8 `json:"max_concurrency"`
 }
 
 // GET /v1/jobs/:jobName
@@ -263,11 +333,48 @@ func createJob() http.Handler {
 			return
 		}
 
+		if jr.OnSuccess == jr.Name || jr.OnFailure == jr.Name || jr.OnExpire == jr.Name {
+			err := &rest.Error{
+				Instance: r.URL.Path,
+				ID:       "invalid_parameter",
+				Title:    "A job type cannot enqueue itself as a follow-up job",
+			}
+			badRequest(w, r, err)
+			return
+		}
+
+		if jr.RateLimit == 0 && (jr.RateIntervalMs > 0 || jr.RateBurst > 0) {
+			badRequest(w, r, createEmptyErr("rate_limit", r.URL.Path))
+			return
+		}
+
+		if jr.MaxConcurrency == 0 && jr.MinConcurrency > 0 {
+			badRequest(w, r, createEmptyErr("max_concurrency", r.URL.Path))
+			return
+		}
+		if jr.MaxConcurrency > 0 && (jr.Concurrency < jr.MinConcurrency || jr.Concurrency > jr.MaxConcurrency) {
+			badRequest(w, r, &rest.Error{
+				Instance: r.URL.Path,
+				ID:       "invalid_parameter",
+				Title:    "Concurrency must be between min_concurrency and max_concurrency",
+			})
+			return
+		}
+
 		jobData := models.Job{
 			Name:             jr.Name,
 			DeliveryStrategy: jr.DeliveryStrategy,
 			Concurrency:      jr.Concurrency,
 			Attempts:         jr.Attempts,
+			OnSuccess:        types.NullString{Valid: jr.OnSuccess != "", String: jr.OnSuccess},
+			OnFailure:        types.NullString{Valid: jr.OnFailure != "", String: jr.OnFailure},
+			OnExpire:         types.NullString{Valid: jr.OnExpire != "", String: jr.OnExpire},
+			RateLimit:        jr.RateLimit,
+			RateIntervalMs:   jr.RateIntervalMs,
+			RateBurst:        jr.RateBurst,
+			Synchronous:      jr.Synchronous,
+			MinConcurrency:   jr.MinConcurrency,
+			MaxConcurrency:   jr.MaxConcurrency,
 		}
 		start := time.Now()
 		job, err := jobs.Create(jobData)
@@ -304,6 +411,28 @@ type EnqueueJobRequest struct {
 	// The latest time we can run this job. If not specified, defaults to null
 	// (never expires).
 	ExpiresAt types.NullTime `json:"expires_at"`
+	// If set, and a job with the same name and debounce key is still queued,
+	// replace that job's data and push its run_after back instead of
+	// enqueueing a new job. Must be sent along with Debounce.
+	DebounceKey string `json:"debounce_key"`
+	// How long to wait after the most recent enqueue before running
+	// a debounced job, for example "30s".
+	Debounce string `json:"debounce"`
+	// If set, the job is blocked until every job in the list has succeeded,
+	// and archived as failed if any of them fail.
+	DependsOn []types.PrefixUUID `json:"depends_on"`
+	// If set, the job is added to the given batch, which must be open.
+	BatchID *types.PrefixUUID `json:"batch_id"`
+	// If set, the job won't be dequeued while ConcurrencyLimit or more jobs
+	// with the same key are in progress, across all job types.
+	ConcurrencyKey string `json:"concurrency_key"`
+	// The maximum number of jobs with ConcurrencyKey that can be in progress
+	// at once. Defaults to 1.
+	ConcurrencyLimit uint8 `json:"concurrency_limit"`
+	// If set, jobs with the same ordering key are dequeued one at a time, in
+	// the order they were enqueued. A job isn't dequeued until every earlier
+	// job with the key has been archived, including retries.
+	OrderingKey string `json:"ordering_key"`
 }
 
 // GET/POST/PUT disambiguator for /v1/jobs/:name/:id
@@ -315,6 +444,9 @@ func handleJobRoute() http.HandlerFunc {
 		} else if r.Method == "PUT" {
 			j := jobEnqueuer{}
 			j.ServeHTTP(w, r)
+		} else if r.Method == "PATCH" {
+			j := jobUpdater{}
+			j.ServeHTTP(w, r)
 		} else if r.Method == "GET" {
 			j := jobStatusGetter{}
 			j.ServeHTTP(w, r)
@@ -415,6 +547,39 @@ func (j *jobEnqueuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, r, createEmptyErr("data", r.URL.Path))
 		return
 	}
+	if ejr.DebounceKey != "" || ejr.Debounce != "" {
+		if ejr.DebounceKey == "" {
+			badRequest(w, r, createEmptyErr("debounce_key", r.URL.Path))
+			return
+		}
+		if ejr.Debounce == "" {
+			badRequest(w, r, createEmptyErr("debounce", r.URL.Path))
+			return
+		}
+		if ejr.RunAfter.Valid {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_parameter",
+				Title:    "Cannot set both run_after and debounce",
+				Detail:   "A debounced job runs once the debounce period has elapsed after the most recent enqueue.",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		debounce, err := time.ParseDuration(ejr.Debounce)
+		if err != nil || debounce <= 0 {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_parameter",
+				Title:    fmt.Sprintf("Invalid debounce duration: %s", ejr.Debounce),
+				Detail:   "Debounce should be a positive duration, like \"30s\" or \"5m\".",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		ejr.RunAfter = types.NullTime{
+			Valid: true,
+			Time:  time.Now().UTC().Add(debounce),
+		}
+	}
 	if !ejr.RunAfter.Valid {
 		ejr.RunAfter = types.NullTime{
 			Valid: true,
@@ -450,8 +615,75 @@ func (j *jobEnqueuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(err)
 		return
 	}
+	if len(ejr.DependsOn) > 0 {
+		if ejr.DebounceKey != "" {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_parameter",
+				Title:    "Cannot set both depends_on and debounce_key",
+				Detail:   "A debounced enqueue can only be merged into a queued job, not a blocked one.",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		for _, parent := range ejr.DependsOn {
+			if parent.String() == id.String() {
+				badRequest(w, r, &rest.Error{
+					ID:       "invalid_parameter",
+					Title:    "A job cannot depend on itself",
+					Instance: r.URL.Path,
+				})
+				return
+			}
+		}
+	}
+	if ejr.BatchID != nil {
+		if ejr.BatchID.Prefix != batches.Prefix {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_prefix",
+				Title:    fmt.Sprintf("Please use %s for the batch_id prefix, not %s", batches.Prefix, ejr.BatchID.Prefix),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		if ejr.DebounceKey != "" {
+			badRequest(w, r, &rest.Error{
+				ID:       "invalid_parameter",
+				Title:    "Cannot set both batch_id and debounce_key",
+				Detail:   "A debounced enqueue can be merged into a queued job that isn't in the batch.",
+				Instance: r.URL.Path,
+			})
+			return
+		}
+	}
+	if ejr.ConcurrencyKey == "" && ejr.ConcurrencyLimit > 0 {
+		badRequest(w, r, createEmptyErr("concurrency_key", r.URL.Path))
+		return
+	}
 	name := jobIdRoute.FindStringSubmatch(r.URL.Path)[1]
-	queuedJob, err := queued_jobs.Enqueue(id, name, ejr.RunAfter.Time, ejr.ExpiresAt, ejr.Data)
+	queuedJob, err := services.Enqueue(id, name, ejr.RunAfter.Time, ejr.ExpiresAt, ejr.Data, queued_jobs.EnqueueOptions{
+		DebounceKey:      ejr.DebounceKey,
+		DependsOn:        ejr.DependsOn,
+		BatchID:          ejr.BatchID,
+		ConcurrencyKey:   ejr.ConcurrencyKey,
+		ConcurrencyLimit: ejr.ConcurrencyLimit,
+		OrderingKey:      ejr.OrderingKey,
+	})
+	if err == batches.ErrNotFound {
+		notFound(w, &rest.Error{
+			Title:    fmt.Sprintf("Batch %s not found", ejr.BatchID.String()),
+			ID:       "batch_not_found",
+			Instance: fmt.Sprintf("/v1/batches/%s", ejr.BatchID.String()),
+		})
+		return
+	}
+	if err == batches.ErrClosed {
+		badRequest(w, r, &rest.Error{
+			Title:    "Batch is closed, so no more jobs can be added to it",
+			ID:       "batch_closed",
+			Instance: fmt.Sprintf("/v1/batches/%s", ejr.BatchID.String()),
+		})
+		return
+	}
 	if err != nil {
 		switch terr := err.(type) {
 		case *queued_jobs.UnknownOrArchivedError:
@@ -475,6 +707,20 @@ func (j *jobEnqueuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				metrics.Increment("enqueue.error.already_archived")
 				return
 			}
+		case *services.UnknownParentError:
+			badRequest(w, r, &rest.Error{
+				ID:       "unknown_parent_job",
+				Title:    terr.Error(),
+				Instance: r.URL.Path,
+			})
+			return
+		case *services.FailedParentError:
+			badRequest(w, r, &rest.Error{
+				ID:       "parent_job_failed",
+				Title:    terr.Error(),
+				Instance: r.URL.Path,
+			})
+			return
 		case *dberror.Error:
 			if terr.Code == dberror.CodeUniqueViolation {
 				queuedJob, err = queued_jobs.Get(id)
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// An UpdateJobRequest is sent in the body of a request to PATCH
+// /v1/jobs/:job-name/:job-id. Fields that are omitted are left unchanged.
+type UpdateJobRequest struct {
+	// New job data.
+	Data json.RawMessage `json:"data"`
+	// The earliest time we can run this job.
+	RunAfter types.NullTime `json:"run_after"`
+	// The latest time we can run this job.
+	ExpiresAt types.NullTime `json:"expires_at"`
+	// Jobs don't have a priority; this is only here so a request that tries
+	// to set one gets an error, instead of having it silently ignored.
+	Priority json.RawMessage `json:"priority"`
+}
+
+// jobUpdater satisfies the Handler interface.
+type jobUpdater struct{}
+
+// PATCH /v1/jobs/:name/:id
+//
+// Update the data, run_after or expires_at fields of a job that hasn't been
+// acquired by a dequeuer yet. Returns a 400 if the job is already in
+// progress or archived.
+func (j *jobUpdater) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		badRequest(w, r, createEmptyErr("data", r.URL.Path))
+		return
+	}
+	defer r.Body.Close()
+	var ujr UpdateJobRequest
+	err := json.NewDecoder(r.Body).Decode(&ujr)
+	if err != nil {
+		badRequest(w, r, &rest.Error{
+			ID:    "invalid_request",
+			Title: "Invalid request: bad JSON. Double check the types of the fields you sent",
+		})
+		return
+	}
+	if ujr.Priority != nil {
+		badRequest(w, r, &rest.Error{
+			ID:       "invalid_parameter",
+			Title:    "Cannot update priority",
+			Detail:   "Jobs are dequeued in the order they were created, and don't have a priority.",
+			Instance: r.URL.Path,
+		})
+		return
+	}
+	if ujr.Data != nil && bytes.Equal(bytes.TrimSpace(ujr.Data), []byte("null")) {
+		badRequest(w, r, &rest.Error{
+			ID:       "invalid_parameter",
+			Title:    "Data cannot be null",
+			Detail:   "Leave out data to keep the job's current data.",
+			Instance: r.URL.Path,
+		})
+		return
+	}
+	if ujr.Data == nil && !ujr.RunAfter.Valid && !ujr.ExpiresAt.Valid {
+		badRequest(w, r, &rest.Error{
+			ID:       "missing_parameter",
+			Title:    "Nothing to update",
+			Detail:   "Please include at least one of data, run_after or expires_at in the request body",
+			Instance: r.URL.Path,
+		})
+		return
+	}
+	if len(ujr.Data) > MAX_ENQUEUE_DATA_SIZE {
+		err := &rest.Error{
+			ID:    "entity_too_large",
+			Title: "Data parameter is too large (100KB max)",
+		}
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(err)
+		return
+	}
+
+	match := jobIdRoute.FindStringSubmatch(r.URL.Path)
+	name := match[1]
+	id, wroteResponse := getId(w, r, match[2])
+	if wroteResponse == true {
+		return
+	}
+
+	updateQueuedJob(w, r, id, name, ujr.RunAfter, ujr.ExpiresAt, ujr.Data, "job.update")
+}
+
+// updateQueuedJob updates a queued job and writes the response, or an
+// appropriate error if the job is in progress, archived, or doesn't exist.
+// Metrics are recorded using the given prefix.
+func updateQueuedJob(w http.ResponseWriter, r *http.Request, id types.PrefixUUID, name string, runAfter types.NullTime, expiresAt types.NullTime, data json.RawMessage, metricPrefix string) {
+	qj, err := queued_jobs.Update(id, name, runAfter, expiresAt, data)
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(qj)
+		go metrics.Increment(metricPrefix + ".success")
+		return
+	}
+	if err == queued_jobs.ErrNotQueued {
+		badRequest(w, r, &rest.Error{
+			ID:       "job_in_progress",
+			Title:    "Cannot update a job that is already in progress",
+			Instance: r.URL.Path,
+		})
+		go metrics.Increment(metricPrefix + ".in_progress")
+		return
+	}
+	if err == queued_jobs.ErrBlocked {
+		badRequest(w, r, &rest.Error{
+			ID:       "job_blocked",
+			Title:    "Cannot update a job that is waiting on other jobs",
+			Instance: r.URL.Path,
+		})
+		go metrics.Increment(metricPrefix + ".blocked")
+		return
+	}
+	if err != queued_jobs.ErrNotFound {
+		writeServerError(w, r, err)
+		go metrics.Increment(metricPrefix + ".error")
+		return
+	}
+
+	aj, err := archived_jobs.GetRetry(id, 3)
+	if err == archived_jobs.ErrNotFound || (err == nil && aj.Name != name) {
+		notFound(w, new404(r))
+		go metrics.Increment(metricPrefix + ".not_found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err)
+		go metrics.Increment(metricPrefix + ".error")
+		return
+	}
+	badRequest(w, r, &rest.Error{
+		ID:       "job_already_archived",
+		Title:    "Cannot update a job that has already been archived",
+		Instance: r.URL.Path,
+	})
+	go metrics.Increment(metricPrefix + ".archived")
+}
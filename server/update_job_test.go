@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/test"
+)
+
+func Test400UpdateNothing(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString("{}")
+	req, _ := http.NewRequest("PATCH", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.ID, "missing_parameter")
+	test.AssertEquals(t, e.Title, "Nothing to update")
+}
+
+func Test400UpdateRandomID(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {}}`)
+	req, _ := http.NewRequest("PATCH", "/v1/jobs/echo/random_id", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+}
+
+func Test400UpdatePriority(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"priority": 5}`)
+	req, _ := http.NewRequest("PATCH", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Cannot update priority")
+}
+
+func Test400UpdateNullData(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": null}`)
+	req, _ := http.NewRequest("PATCH", "/v1/jobs/echo/job_6740b44e-13b9-475d-af06-979627e0e0d6", b)
+	req.SetBasicAuth("foo", "bar")
+	Get(u).ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.Title, "Data cannot be null")
+}
@@ -14,6 +14,11 @@ import (
 // expected prefix. Returns the correct ID, and a boolean describing whether
 // the helper has written a response.
 func getId(w http.ResponseWriter, r *http.Request, idStr string) (types.PrefixUUID, bool) {
+	return getPrefixedId(w, r, idStr, queued_jobs.Prefix)
+}
+
+// getPrefixedId is like getId, but checks the ID against the given prefix.
+func getPrefixedId(w http.ResponseWriter, r *http.Request, idStr string, prefix string) (types.PrefixUUID, bool) {
 	id, err := types.NewPrefixUUID(idStr)
 	if err != nil {
 		badRequest(w, r, &rest.Error{
@@ -22,10 +27,10 @@ func getId(w http.ResponseWriter, r *http.Request, idStr string) (types.PrefixUU
 		})
 		return id, true
 	}
-	if id.Prefix != queued_jobs.Prefix {
+	if id.Prefix != prefix {
 		badRequest(w, r, &rest.Error{
 			ID:    "invalid_prefix",
-			Title: fmt.Sprintf("Please use %s for the uuid prefix, not %s", queued_jobs.Prefix, id.Prefix),
+			Title: fmt.Sprintf("Please use %s for the uuid prefix, not %s", prefix, id.Prefix),
 		})
 		return id, true
 	}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/workers"
+)
+
+// GET /v1/workers
+//
+// List the dequeuer processes that have registered, oldest first, with the
+// time of their last heartbeat.
+func listWorkers() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := workers.GetAll()
+		if err != nil {
+			writeServerError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			Workers []*models.Worker `json:"workers"`
+		}{ws})
+	})
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
+	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// BatchCompleteData is the data sent to a batch's on_complete job.
+type BatchCompleteData struct {
+	// BatchID is the ID of the batch that completed.
+	BatchID types.PrefixUUID `json:"batch_id"`
+	// Data is the data the batch was created with.
+	Data json.RawMessage `json:"data"`
+	// Counts is the number of jobs in the batch with each status.
+	Counts map[models.JobStatus]int64 `json:"counts"`
+}
+
+// CloseBatch closes the batch, so no more jobs can be enqueued into it. If
+// every job in the batch has already been archived, the batch is completed
+// right away.
+func CloseBatch(id types.PrefixUUID) (*models.Batch, error) {
+	b, err := batches.Close(id)
+	if err != nil {
+		return nil, err
+	}
+	go metrics.Increment("batch.close.success")
+	if b.CompletedAt.Valid {
+		return b, nil
+	}
+	if err := completeBatch(id); err != nil {
+		return nil, err
+	}
+	return batches.Get(id)
+}
+
+// completeBatch marks the batch as complete if it's closed and none of its
+// jobs are left in queued_jobs, and enqueues its on_complete job in the same
+// transaction. It's safe to call from several processes at once; only one of
+// them will complete the batch.
+func completeBatch(id types.PrefixUUID) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	b, err := batches.CompleteTx(tx, id)
+	if err == batches.ErrNotComplete {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b.OnComplete.Valid {
+		counts, err := batches.GetCountsTx(tx, id)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(BatchCompleteData{
+			BatchID: id,
+			Data:    b.Data,
+			Counts:  counts,
+		})
+		if err != nil {
+			return err
+		}
+		jobID, err := types.GenerateUUID(queued_jobs.Prefix)
+		if err != nil {
+			return err
+		}
+		_, err = queued_jobs.EnqueueTx(tx, jobID, b.OnComplete.String, time.Now().UTC(), types.NullTime{Valid: false}, data, queued_jobs.EnqueueOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	go metrics.Increment("batch.complete.success")
+	return nil
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/downstream"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/circuit_breakers"
+)
+
+// DefaultCircuitThreshold is the number of consecutive downstream failures
+// for a job type that open its circuit.
+const DefaultCircuitThreshold = 5
+
+// DefaultCircuitCooldown is how long an open circuit waits before sending a
+// probe to the downstream server.
+var DefaultCircuitCooldown = 30 * time.Second
+
+// DefaultCircuitCacheTTL is how long a CircuitBreaker trusts the circuit
+// state it last read or wrote, if CacheTTL isn't set.
+var DefaultCircuitCacheTTL = 2 * time.Second
+
+// A CircuitBreaker stops sending jobs of a type to the downstream server after
+// it fails Threshold times in a row, so the jobs don't use up all of their
+// attempts while the server is down.
+//
+// While the circuit is open, dequeuers don't acquire jobs of that type. Once
+// Cooldown has elapsed, a single job is sent as a probe; if it's accepted the
+// circuit closes, and if it fails the circuit opens again. Circuit state is
+// stored in the database, so it's shared by every dequeuer, and cached in
+// memory for CacheTTL so that healthy job types don't touch the database.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures that open the circuit.
+	// Set to 0 to disable the circuit breaker.
+	Threshold uint32
+
+	// Cooldown is how long to wait after the circuit opens before sending a
+	// probe, and how long to wait for a probe before sending another one.
+	Cooldown time.Duration
+
+	// CacheTTL is how long to remember each job type's circuit state. A
+	// circuit opened by another process may not be noticed for up to
+	// CacheTTL. Defaults to DefaultCircuitCacheTTL.
+	CacheTTL time.Duration
+
+	mu     sync.Mutex
+	states map[string]cachedCircuit
+}
+
+type cachedCircuit struct {
+	state    models.CircuitState
+	failures uint32
+	expires  time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker with the default threshold and
+// cooldown.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: DefaultCircuitThreshold,
+		Cooldown:  DefaultCircuitCooldown,
+	}
+}
+
+// Allow reports whether a job of the given type can be sent downstream, and
+// whether it's being sent as a probe. If the circuit state can't be read,
+// the job is let through.
+func (cb *CircuitBreaker) Allow(name string) (ok bool, probe bool) {
+	if cb == nil || cb.Threshold == 0 {
+		return true, false
+	}
+	if cached, ok := cb.cached(name); ok && cached.state == models.CircuitClosed {
+		return true, false
+	}
+	c, err := circuit_breakers.Get(name)
+	if err == circuit_breakers.ErrNotFound {
+		cb.cache(name, models.CircuitClosed, 0)
+		return true, false
+	}
+	if err == nil {
+		cb.cache(name, c.State, c.Failures)
+		if c.State == models.CircuitClosed {
+			return true, false
+		}
+	}
+	if err != nil {
+		log.Printf("Could not get circuit breaker for %s: %s", name, err.Error())
+		return true, false
+	}
+	_, err = circuit_breakers.HalfOpen(name, cb.Cooldown)
+	if err == circuit_breakers.ErrNoProbe {
+		go metrics.Increment(fmt.Sprintf("circuit_breaker.%s.rejected", name))
+		return false, false
+	}
+	if err != nil {
+		log.Printf("Could not half-open circuit breaker for %s: %s", name, err.Error())
+		return true, false
+	}
+	go metrics.Increment(fmt.Sprintf("circuit_breaker.%s.half_open", name))
+	return true, true
+}
+
+// Record updates the circuit for the given job type with the result of a
+// request to the downstream server. Errors that don't say anything about the
+// downstream server's health, like 4xx responses, aren't counted.
+func (cb *CircuitBreaker) Record(name string, err error, probe bool) {
+	if cb == nil || cb.Threshold == 0 {
+		return
+	}
+	if err == nil {
+		// Only write to the database if there are failures to reset.
+		if cached, ok := cb.cached(name); ok && !probe && cached.state == models.CircuitClosed && cached.failures == 0 {
+			return
+		}
+		_, cerr := circuit_breakers.Close(name)
+		if cerr == nil || cerr == circuit_breakers.ErrNotFound {
+			cb.cache(name, models.CircuitClosed, 0)
+		}
+		if cerr == nil && probe {
+			log.Printf("Closing circuit breaker for %s", name)
+			go metrics.Increment(fmt.Sprintf("circuit_breaker.%s.closed", name))
+		} else if cerr != nil && cerr != circuit_breakers.ErrNotFound {
+			log.Printf("Could not close circuit breaker for %s: %s", name, cerr.Error())
+		}
+		return
+	}
+	if !isDownstreamFailure(err) {
+		return
+	}
+	c, err := circuit_breakers.RecordFailure(name, cb.Threshold, cb.Cooldown)
+	if err != nil {
+		log.Printf("Could not record failure for circuit breaker %s: %s", name, err.Error())
+		return
+	}
+	cb.cache(name, c.State, c.Failures)
+	go metrics.Increment(fmt.Sprintf("circuit_breaker.%s.failure", name))
+	if c.State == models.CircuitOpen && (probe || c.Failures == cb.Threshold) {
+		log.Printf("Opening circuit breaker for %s after %d consecutive failures", name, c.Failures)
+		go metrics.Increment(fmt.Sprintf("circuit_breaker.%s.opened", name))
+	}
+}
+
+// cached returns the job type's circuit state as it was last read or
+// written, if that was less than CacheTTL ago.
+func (cb *CircuitBreaker) cached(name string) (cachedCircuit, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.states[name]
+	if !ok || time.Now().After(c.expires) {
+		return cachedCircuit{}, false
+	}
+	return c, true
+}
+
+func (cb *CircuitBreaker) cache(name string, state models.CircuitState, failures uint32) {
+	ttl := cb.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCircuitCacheTTL
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.states == nil {
+		cb.states = make(map[string]cachedCircuit)
+	}
+	cb.states[name] = cachedCircuit{
+		state:    state,
+		failures: failures,
+		expires:  time.Now().Add(ttl),
+	}
+}
+
+// isDownstreamFailure returns true if err suggests the downstream server is
+// unhealthy. Timeouts aren't counted, since we assume the request made it.
+func isDownstreamFailure(err error) bool {
+	switch rerr := err.(type) {
+	case *downstream.Error:
+		return rerr.StatusCode >= 500
+	case *rest.Error:
+		return rerr.StatusCode >= 500 || rerr.ID == "service_unavailable"
+	}
+	return !isTimeout(err)
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/downstream"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/test"
+)
+
+func TestIsDownstreamFailure(t *testing.T) {
+	t.Parallel()
+	test.AssertEquals(t, isDownstreamFailure(&downstream.Error{StatusCode: 502, Err: &rest.Error{Title: "Bad gateway"}}), true)
+	test.AssertEquals(t, isDownstreamFailure(&downstream.Error{StatusCode: 404, Err: &rest.Error{Title: "Not found"}}), false)
+	test.AssertEquals(t, isDownstreamFailure(&rest.Error{StatusCode: 500, Title: "Server error"}), true)
+	test.AssertEquals(t, isDownstreamFailure(&rest.Error{ID: "service_unavailable", Title: "Unavailable"}), true)
+	test.AssertEquals(t, isDownstreamFailure(&rest.Error{StatusCode: 400, Title: "Bad request"}), false)
+	test.AssertEquals(t, isDownstreamFailure(errors.New("dial tcp: connection refused")), true)
+	test.AssertEquals(t, isDownstreamFailure(errors.New("net/http: request canceled (Client.Timeout exceeded while awaiting headers)")), false)
+}
+
+func TestDisabledCircuitBreakerAllows(t *testing.T) {
+	t.Parallel()
+	var cb *CircuitBreaker
+	ok, probe := cb.Allow("echo")
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, probe, false)
+
+	cb = &CircuitBreaker{Threshold: 0}
+	ok, probe = cb.Allow("echo")
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, probe, false)
+}
+
+func TestCircuitBreakerCacheExpires(t *testing.T) {
+	t.Parallel()
+	cb := &CircuitBreaker{Threshold: 1, CacheTTL: 20 * time.Millisecond}
+	_, ok := cb.cached("echo")
+	test.AssertEquals(t, ok, false)
+
+	cb.cache("echo", models.CircuitOpen, 3)
+	c, ok := cb.cached("echo")
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, c.state, models.CircuitOpen)
+	test.AssertEquals(t, c.failures, uint32(3))
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok = cb.cached("echo")
+	test.AssertEquals(t, ok, false)
+}
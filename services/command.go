@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models"
+)
+
+// ExitCodeNotRetryable is the exit code a command should use to report that
+// a job failed and shouldn't be retried. It's EX_DATAERR from sysexits.h.
+const ExitCodeNotRetryable = 65
+
+// MaxCommandOutput is the maximum number of bytes of a command's output that
+// are kept as the failure detail. If a command writes more than this, the
+// beginning of the output is dropped.
+var MaxCommandOutput = 4096
+
+// CommandWaitDelay is how long to wait for a command's output to be closed
+// once the command has exited. A process the command started in the
+// background can hold the output open; after CommandWaitDelay the output is
+// closed and the job finishes with whatever was written so far.
+var CommandWaitDelay = time.Second
+
+// A Command is an executable to run for each job of a type.
+type Command struct {
+	// Path is the executable to run. If it doesn't contain a slash, it's
+	// looked up in $PATH.
+	Path string
+	Args []string
+
+	// Env is added to the dequeuer's environment, in "KEY=value" form.
+	Env []string
+
+	// Timeout is how long the command has to finish. After that, the command
+	// and any processes it started are killed, and the job is failed and
+	// retried. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// CommandResult is the result recorded for a job whose command failed.
+type CommandResult struct {
+	// ExitCode is -1 if the command couldn't be started, or was killed.
+	ExitCode int `json:"exit_code"`
+	// Output is the end of the command's combined stdout and stderr.
+	Output string `json:"output"`
+	// Truncated is true if Output was cut down to MaxCommandOutput bytes.
+	Truncated bool   `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+}
+
+// A CommandWorker is a Worker that runs a local executable for each job,
+// instead of sending it to a downstream server.
+//
+// The job's data is written to the command's stdin, and the job's ID, type
+// and remaining attempts are set in the RICKOVER_JOB_ID, RICKOVER_JOB_NAME
+// and RICKOVER_ATTEMPTS environment variables. If the command exits 0 the
+// job succeeded. If it exits with ExitCodeNotRetryable the job failed and
+// isn't retried; any other exit code fails the job and retries it. Jobs whose
+// type has no command are failed without being retried.
+type CommandWorker struct {
+	// Multiplier used to determine how long to sleep between failed attempts
+	// to acquire a job. The formula for sleeps is 10 * (Factor) ^ (Attempts)
+	// ms. Set to 0 to not sleep between attempts.
+	SleepFactor float64
+
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewCommandWorker creates a CommandWorker with no commands.
+func NewCommandWorker() *CommandWorker {
+	return &CommandWorker{
+		SleepFactor: defaultSleepFactor,
+		commands:    make(map[string]Command),
+	}
+}
+
+// Handle registers cmd to run jobs of the given type.
+func (c *CommandWorker) Handle(name string, cmd Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.commands == nil {
+		c.commands = make(map[string]Command)
+	}
+	c.commands[name] = cmd
+}
+
+func (c *CommandWorker) command(name string) (Command, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cmd, ok := c.commands[name]
+	return cmd, ok
+}
+
+// DoWork runs the command for the job's type, and records whether the job
+// succeeded or failed.
+func (c *CommandWorker) DoWork(qj *models.QueuedJob) error {
+	return c.DoWorkContext(context.Background(), qj)
+}
+
+// DoWorkContext is like DoWork, but kills the command if ctx is cancelled
+// before it finishes. The job is then released back to the queue without
+// using up an attempt, and DoWorkContext returns ctx.Err().
+func (c *CommandWorker) DoWorkContext(ctx context.Context, qj *models.QueuedJob) error {
+	if qj.ExpiresAt.Valid && time.Since(qj.ExpiresAt.Time) >= 0 {
+		return createAndDelete(qj.ID, qj.Name, models.StatusExpired, qj.Attempts)
+	}
+	cmd, ok := c.command(qj.Name)
+	if !ok {
+		log.Printf("No command registered for job %s (type %s)", qj.ID.String(), qj.Name)
+		go metrics.Increment(fmt.Sprintf("command.%s.no_command", qj.Name))
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, false)
+	}
+	start := time.Now()
+	res := runCommand(ctx, cmd, qj)
+	go metrics.Time(fmt.Sprintf("command.%s.latency", qj.Name), time.Since(start))
+	if ctx.Err() != nil {
+		return releaseCancelledJob(ctx, qj)
+	}
+	if res.ExitCode == 0 && res.Error == "" {
+		go metrics.Increment(fmt.Sprintf("command.%s.succeeded", qj.Name))
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, qj.Attempts, true)
+	}
+	log.Printf("command for job %s (type %s) failed with exit code %d: %s", qj.ID.String(), qj.Name, res.ExitCode, res.Output)
+	result, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	retryable := res.ExitCode != ExitCodeNotRetryable
+	if retryable {
+		go metrics.Increment(fmt.Sprintf("command.%s.failed", qj.Name))
+	} else {
+		go metrics.Increment(fmt.Sprintf("command.%s.failed.permanent", qj.Name))
+	}
+	return HandleStatusCallbackResult(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, retryable, result)
+}
+
+// runCommand runs cmd for qj, and kills it and any processes it started if
+// ctx is cancelled or cmd.Timeout elapses.
+func runCommand(ctx context.Context, cmd Command, qj *models.QueuedJob) *CommandResult {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := &CommandResult{ExitCode: -1}
+	// The command writes to a pipe instead of to out directly, so Wait
+	// doesn't block until every process holding the output open has exited.
+	r, w, err := os.Pipe()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer r.Close()
+	ec := exec.Command(cmd.Path, cmd.Args...)
+	ec.Stdin = bytes.NewReader(qj.Data)
+	ec.Stdout = w
+	ec.Stderr = w
+	ec.Env = append(os.Environ(),
+		"RICKOVER_JOB_ID="+qj.ID.String(),
+		"RICKOVER_JOB_NAME="+qj.Name,
+		"RICKOVER_ATTEMPTS="+strconv.Itoa(int(qj.Attempts)),
+	)
+	ec.Env = append(ec.Env, cmd.Env...)
+	setProcessGroup(ec)
+
+	err = ec.Start()
+	w.Close()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	out := &tailWriter{max: MaxCommandOutput}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(out, r)
+		close(copied)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- ec.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(ec)
+		err = <-done
+		res.Error = fmt.Sprintf("command killed: %s", ctx.Err())
+		go metrics.Increment(fmt.Sprintf("command.%s.killed", qj.Name))
+	}
+	// A process the command started in the background can keep the output
+	// open after the command exits; give up on it after CommandWaitDelay.
+	select {
+	case <-copied:
+	case <-time.After(CommandWaitDelay):
+		r.Close()
+		<-copied
+	}
+	res.Output, res.Truncated = out.String(), out.truncated
+	if res.Error != "" {
+		return res
+	}
+	if err == nil {
+		res.ExitCode = 0
+		return res
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if code, ok := exitCode(exitErr); ok {
+			res.ExitCode = code
+			return res
+		}
+	}
+	res.Error = err.Error()
+	return res
+}
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = append(w.buf[:0], w.buf[len(w.buf)-w.max:]...)
+		w.truncated = true
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	return string(w.buf)
+}
+
+// Sleep returns the amount of time to sleep between failed attempts to
+// acquire a job.
+func (c *CommandWorker) Sleep(failedAttempts uint32) time.Duration {
+	return sleepFor(c.SleepFactor, failedAttempts)
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package services
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so
+// killProcessGroup can kill anything it starts too.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	// A negative pid kills the whole process group.
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func exitCode(err *exec.ExitError) (int, bool) {
+	status, ok := err.Sys().(syscall.WaitStatus)
+	if !ok || !status.Exited() {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
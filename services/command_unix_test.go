@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/test"
+)
+
+func shCommand(script string) Command {
+	return Command{Path: "/bin/sh", Args: []string{"-c", script}, Timeout: 5 * time.Second}
+}
+
+func TestRunCommandSucceeds(t *testing.T) {
+	t.Parallel()
+	qj := testQueuedJob()
+	qj.Attempts = 3
+	qj.Data = json.RawMessage(`{"to": "+14105551234"}`)
+	res := runCommand(context.Background(), shCommand(`cat; echo " $RICKOVER_JOB_ID $RICKOVER_JOB_NAME $RICKOVER_ATTEMPTS"`), qj)
+	test.AssertEquals(t, res.ExitCode, 0)
+	test.AssertEquals(t, res.Error, "")
+	test.AssertEquals(t, res.Output, `{"to": "+14105551234"} job_6740b44e-13b9-475d-af06-979627e0e0d6 echo 3`+"\n")
+}
+
+func TestRunCommandExitCode(t *testing.T) {
+	t.Parallel()
+	res := runCommand(context.Background(), shCommand("echo bad data >&2; exit 65"), testQueuedJob())
+	test.AssertEquals(t, res.ExitCode, ExitCodeNotRetryable)
+	test.AssertEquals(t, res.Output, "bad data\n")
+	test.AssertEquals(t, res.Truncated, false)
+}
+
+func TestRunCommandTruncatesOutput(t *testing.T) {
+	t.Parallel()
+	res := runCommand(context.Background(), shCommand("i=0; while [ $i -lt 1000 ]; do echo 0123456789; i=$((i+1)); done; echo end; exit 1"), testQueuedJob())
+	test.AssertEquals(t, res.ExitCode, 1)
+	test.AssertEquals(t, res.Truncated, true)
+	test.AssertEquals(t, len(res.Output), MaxCommandOutput)
+	test.Assert(t, strings.HasSuffix(res.Output, "0123456789\nend\n"), res.Output)
+}
+
+func TestRunCommandTimeoutKillsProcessGroup(t *testing.T) {
+	t.Parallel()
+	cmd := shCommand("sleep 10 & sleep 10; wait")
+	cmd.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	res := runCommand(context.Background(), cmd, testQueuedJob())
+	// If the background sleep wasn't killed it would hold stdout open, and
+	// Wait wouldn't return until it exited.
+	test.Assert(t, time.Since(start) < 5*time.Second, "command wasn't killed")
+	test.AssertEquals(t, res.ExitCode, -1)
+	test.Assert(t, strings.Contains(res.Error, "deadline exceeded"), res.Error)
+}
+
+func TestRunCommandDoesNotWaitForBackgroundProcesses(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	res := runCommand(context.Background(), shCommand("sleep 10 & echo done"), testQueuedJob())
+	test.Assert(t, time.Since(start) < 5*time.Second, "waited for the background process")
+	test.AssertEquals(t, res.ExitCode, 0)
+	test.AssertEquals(t, res.Error, "")
+	test.AssertEquals(t, res.Output, "done\n")
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	t.Parallel()
+	res := runCommand(context.Background(), Command{Path: "/nonexistent/command"}, testQueuedJob())
+	test.AssertEquals(t, res.ExitCode, -1)
+	test.Assert(t, res.Error != "", "expected an error starting the command")
+}
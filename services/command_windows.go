@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package services
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the command itself; processes it started keep
+// running.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
+
+func exitCode(err *exec.ExitError) (int, bool) {
+	status, ok := err.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
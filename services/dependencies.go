@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// UnknownParentError is returned when a job depends on a job that doesn't
+// exist.
+type UnknownParentError struct {
+	ID types.PrefixUUID
+}
+
+func (e *UnknownParentError) Error() string {
+	return fmt.Sprintf("Parent job %s does not exist", e.ID.String())
+}
+
+// FailedParentError is returned when a job depends on a job that has already
+// been archived without succeeding.
+type FailedParentError struct {
+	ID     types.PrefixUUID
+	Status models.JobStatus
+}
+
+func (e *FailedParentError) Error() string {
+	return fmt.Sprintf("Parent job %s has already been archived with status %s", e.ID.String(), e.Status)
+}
+
+// checkParents returns an UnknownParentError if any job in dependsOn doesn't
+// exist, or a FailedParentError if any of them was archived without
+// succeeding.
+func checkParents(dependsOn []types.PrefixUUID) error {
+	for _, parent := range dependsOn {
+		_, err := queued_jobs.GetRetry(parent, 3)
+		if err == nil {
+			continue
+		}
+		if err != queued_jobs.ErrNotFound {
+			return err
+		}
+		aj, err := archived_jobs.GetRetry(parent, 3)
+		if err == archived_jobs.ErrNotFound {
+			return &UnknownParentError{ID: parent}
+		}
+		if err != nil {
+			return err
+		}
+		if aj.Status != models.StatusSucceeded {
+			return &FailedParentError{ID: parent, Status: aj.Status}
+		}
+	}
+	return nil
+}
+
+// resolveDependencies unblocks a blocked job if all of its parents have
+// succeeded, or archives it as failed if any of them didn't. If some parents
+// are still queued or in progress, the job is left alone.
+func resolveDependencies(qj *models.QueuedJob) error {
+	for _, parent := range qj.DependsOn {
+		aj, err := archived_jobs.GetRetry(parent, 3)
+		if err == archived_jobs.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if aj.Status != models.StatusSucceeded {
+			log.Printf("Parent job %s of job %s has status %s, archiving it as failed", parent.String(), qj.ID.String(), aj.Status)
+			go metrics.Increment("dependencies.parent_failed")
+			err := createAndDelete(qj.ID, qj.Name, models.StatusFailed, qj.Attempts)
+			if err == queued_jobs.ErrNotFound {
+				// Another parent failed at the same time and archived it.
+				return nil
+			}
+			return err
+		}
+	}
+	_, err := queued_jobs.Unblock(qj.ID)
+	if err == queued_jobs.ErrNotFound {
+		// Already unblocked by another parent.
+		return nil
+	}
+	if err == nil {
+		go metrics.Increment("dependencies.unblocked")
+	}
+	return err
+}
+
+// resolveDependents resolves every blocked job that depends on the archived
+// job with the given id. Errors are logged, since the parent has already been
+// archived successfully; ResolveBlockedJobs picks up any jobs left blocked.
+func resolveDependents(id types.PrefixUUID) {
+	dependents, err := queued_jobs.GetBlockedDependents(id)
+	if err != nil {
+		log.Printf("Could not find jobs that depend on %s: %s", id.String(), err.Error())
+		go metrics.Increment("dependencies.get.error")
+		return
+	}
+	for _, qj := range dependents {
+		if err := resolveDependencies(qj); err != nil {
+			log.Printf("Could not resolve dependencies for job %s: %s", qj.ID.String(), err.Error())
+			go metrics.Increment("dependencies.resolve.error")
+		}
+	}
+}
+
+// ResolveBlockedJobs unblocks or fails blocked jobs whose parents have all
+// been archived, and returns the number of jobs it resolved. Jobs are
+// resolved when their last parent is archived, but if that fails, or the
+// process dies in between, the job would otherwise stay blocked forever.
+func ResolveBlockedJobs() (int, error) {
+	jobs, err := queued_jobs.GetResolvableBlockedJobs()
+	if err != nil {
+		return 0, err
+	}
+	resolved := 0
+	for _, qj := range jobs {
+		if err := resolveDependencies(qj); err != nil {
+			log.Printf("Could not resolve dependencies for job %s: %s", qj.ID.String(), err.Error())
+			go metrics.Increment("dependencies.resolve.error")
+			continue
+		}
+		resolved++
+	}
+	return resolved, nil
+}
+
+// WatchBlockedJobs resolves blocked jobs whose parents have all been archived
+// every interval. See ResolveBlockedJobs.
+func WatchBlockedJobs(interval time.Duration) {
+	WatchBlockedJobsContext(context.Background(), interval)
+}
+
+// WatchBlockedJobsContext is like WatchBlockedJobs, but returns once ctx is
+// cancelled.
+func WatchBlockedJobsContext(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		count, err := ResolveBlockedJobs()
+		if err != nil {
+			log.Printf("Error resolving blocked jobs: %s\n", err.Error())
+		}
+		if count > 0 {
+			log.Printf("Resolved %d blocked jobs whose parents had all been archived", count)
+			go metrics.Measure("dependencies.resolved", int64(count))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/downstream"
+)
+
+// DownstreamStats summarizes the requests a JobProcessor has made to the
+// downstream server for a job type.
+type DownstreamStats struct {
+	Requests int
+	// Errors is the number of requests that suggest the downstream server is
+	// struggling to keep up: 5xx and 429 responses, timeouts and connection
+	// errors.
+	Errors       int
+	TotalLatency time.Duration
+}
+
+// ErrorRate returns the fraction of requests that were errors, or 0 if there
+// were no requests.
+func (s DownstreamStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// MeanLatency returns the average time a request took, or 0 if there were no
+// requests.
+func (s DownstreamStats) MeanLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+var downstreamStatsMu sync.Mutex
+var downstreamStats = make(map[string]*DownstreamStats)
+
+func recordDownstreamRequest(name string, latency time.Duration, err error) {
+	downstreamStatsMu.Lock()
+	defer downstreamStatsMu.Unlock()
+	s, ok := downstreamStats[name]
+	if !ok {
+		s = new(DownstreamStats)
+		downstreamStats[name] = s
+	}
+	s.Requests++
+	s.TotalLatency += latency
+	if isOverloadError(err) {
+		s.Errors++
+	}
+}
+
+// TakeDownstreamStats returns the stats for requests for the given job type
+// since the last call, and starts counting again from zero.
+func TakeDownstreamStats(name string) DownstreamStats {
+	downstreamStatsMu.Lock()
+	defer downstreamStatsMu.Unlock()
+	s, ok := downstreamStats[name]
+	if !ok {
+		return DownstreamStats{}
+	}
+	delete(downstreamStats, name)
+	return *s
+}
+
+// isOverloadError returns true if err suggests the downstream server can't
+// keep up with the requests we're sending. Other 4xx responses are a problem
+// with the job, not the server.
+func isOverloadError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch rerr := err.(type) {
+	case *downstream.Error:
+		return rerr.StatusCode >= 500 || rerr.StatusCode == http.StatusTooManyRequests
+	case *rest.Error:
+		return rerr.StatusCode >= 500 || rerr.StatusCode == http.StatusTooManyRequests || rerr.ID == "service_unavailable"
+	}
+	return true
+}
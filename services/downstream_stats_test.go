@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/downstream"
+	"github.com/Shyp/rickover/test"
+)
+
+func TestTakeDownstreamStats(t *testing.T) {
+	t.Parallel()
+	name := "downstream-stats-test"
+	recordDownstreamRequest(name, 100*time.Millisecond, nil)
+	recordDownstreamRequest(name, 300*time.Millisecond, &downstream.Error{StatusCode: 503, Err: &rest.Error{}})
+	recordDownstreamRequest(name, 200*time.Millisecond, &downstream.Error{StatusCode: 400, Err: &rest.Error{}})
+	recordDownstreamRequest(name, 200*time.Millisecond, &downstream.Error{StatusCode: 429, Err: &rest.Error{}})
+	s := TakeDownstreamStats(name)
+	test.AssertEquals(t, s.Requests, 4)
+	test.AssertEquals(t, s.Errors, 2)
+	test.AssertEquals(t, s.ErrorRate(), 0.5)
+	test.AssertEquals(t, s.MeanLatency(), 200*time.Millisecond)
+
+	s = TakeDownstreamStats(name)
+	test.AssertEquals(t, s.Requests, 0)
+	test.AssertEquals(t, s.ErrorRate(), float64(0))
+	test.AssertEquals(t, s.MeanLatency(), time.Duration(0))
+}
+
+func TestIsOverloadError(t *testing.T) {
+	t.Parallel()
+	test.AssertEquals(t, isOverloadError(nil), false)
+	test.AssertEquals(t, isOverloadError(errors.New("connection refused")), true)
+	test.AssertEquals(t, isOverloadError(&rest.Error{ID: "service_unavailable"}), true)
+	test.AssertEquals(t, isOverloadError(&downstream.Error{StatusCode: 404, Err: &rest.Error{}}), false)
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// Enqueue enqueues a job with the given options.
+//
+// If opts.DependsOn is set, the job won't run until every job in it has been
+// archived with the status "succeeded", and if any of them fails or expires,
+// the job is archived as failed without running. Every parent must already
+// exist, either in queued_jobs or archived_jobs.
+//
+// If opts.BatchID is set and the batch doesn't exist, batches.ErrNotFound is
+// returned, and if it's been closed, batches.ErrClosed is returned. Other
+// errors are returned the same way as queued_jobs.EnqueueWithOptions.
+func Enqueue(id types.PrefixUUID, name string, runAfter time.Time, expiresAt types.NullTime, data json.RawMessage, opts queued_jobs.EnqueueOptions) (*models.QueuedJob, error) {
+	if err := checkParents(opts.DependsOn); err != nil {
+		return nil, err
+	}
+	qj, err := queued_jobs.EnqueueWithOptions(id, name, runAfter, expiresAt, data, opts)
+	if _, ok := err.(*queued_jobs.UnknownOrArchivedError); ok && opts.BatchID != nil {
+		b, getErr := batches.Get(*opts.BatchID)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if b.ClosedAt.Valid {
+			return nil, batches.ErrClosed
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.DependsOn) == 0 {
+		return qj, nil
+	}
+	// Parents that finished before the insert won't see this job, so check
+	// them again now.
+	if err := resolveDependencies(qj); err != nil {
+		return nil, err
+	}
+	updated, err := queued_jobs.Get(qj.ID)
+	if err == nil {
+		return updated, nil
+	}
+	return qj, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/queued_jobs"
+)
+
+// ArchiveExpiredJobs archives every queued job whose expires_at has passed
+// with the status "expired", in batches of queued_jobs.ExpiredJobLimit, and
+// returns the number of jobs archived. Dequeuers archive expired jobs when
+// they acquire them, but jobs of a paused or backed up job type can sit in the
+// queue long after they expire.
+//
+// If the job type has an on_expire follow-up, it's enqueued along with the
+// archived job. Expired jobs don't get the on_failure follow-up.
+func ArchiveExpiredJobs() (int, error) {
+	archived := 0
+	for {
+		jobs, err := queued_jobs.AcquireExpired()
+		if err != nil {
+			return archived, err
+		}
+		for _, qj := range jobs {
+			err := createAndDelete(qj.ID, qj.Name, models.StatusExpired, qj.Attempts)
+			if err != nil {
+				log.Printf("Error archiving expired job %s: %s\n", qj.ID.String(), err.Error())
+				go metrics.Increment("expired_jobs.archive.error")
+				// Put the job back in the queue, so the next sweep (or a
+				// dequeuer) archives it as expired. If that fails too, the
+				// stuck job sweeper fails it.
+				if err := queued_jobs.Release(qj.ID); err != nil && err != queued_jobs.ErrNotFound {
+					log.Printf("Error releasing expired job %s: %s\n", qj.ID.String(), err.Error())
+				}
+				continue
+			}
+			archived++
+			go metrics.Increment(fmt.Sprintf("expired_jobs.%s.archived", qj.Name))
+		}
+		if len(jobs) < queued_jobs.ExpiredJobLimit {
+			return archived, nil
+		}
+	}
+}
+
+// WatchExpiredJobs archives expired jobs every interval. See
+// ArchiveExpiredJobs.
+func WatchExpiredJobs(interval time.Duration) {
+	WatchExpiredJobsContext(context.Background(), interval)
+}
+
+// WatchExpiredJobsContext is like WatchExpiredJobs, but returns once ctx is
+// cancelled.
+func WatchExpiredJobsContext(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		count, err := ArchiveExpiredJobs()
+		if err != nil {
+			log.Printf("Error archiving expired jobs: %s\n", err.Error())
+		}
+		if count > 0 {
+			go metrics.Measure("expired_jobs.archived", int64(count))
+		}
+	}
+}
@@ -1,15 +1,29 @@
 package services
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/workers"
 )
 
+// HeartbeatInterval is how often dequeuer processes send a heartbeat.
+var HeartbeatInterval = 10 * time.Second
+
+// DeadWorkerTimeout is how long a dequeuer process can go without sending a
+// heartbeat before ArchiveStuckJobs fails the jobs it's holding. It allows for
+// a few missed heartbeats on top of a downstream request that takes the full
+// DefaultTimeout.
+var DeadWorkerTimeout = 3*HeartbeatInterval + DefaultTimeout
+
 // ArchiveStuckJobs marks as failed any queued jobs with an updated_at
-// timestamp older than the olderThan value.
+// timestamp older than the olderThan value, and any in-progress jobs held by
+// workers that haven't sent a heartbeat in DeadWorkerTimeout. Jobs the
+// downstream server has accepted aren't held by a worker, so only the first
+// check applies to them.
 func ArchiveStuckJobs(olderThan time.Duration) error {
 	var olderThanTime time.Time
 	if olderThan >= 0 {
@@ -21,25 +35,56 @@ func ArchiveStuckJobs(olderThan time.Duration) error {
 	if err != nil {
 		return err
 	}
+	failStuckJobs(jobs, "stuck job")
+
+	heartbeatBefore := time.Now().Add(-1 * DeadWorkerTimeout)
+	jobs, err = queued_jobs.GetDeadWorkerJobs(heartbeatBefore)
+	if err != nil {
+		return err
+	}
+	failStuckJobs(jobs, "job held by a dead worker")
+	deleted, err := workers.DeleteDead(heartbeatBefore)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d dead workers", deleted)
+	}
+	return nil
+}
+
+func failStuckJobs(jobs []*models.QueuedJob, description string) {
 	for _, qj := range jobs {
-		err = HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true)
+		err := HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true)
 		if err == nil {
-			log.Printf("Found stuck job %s and marked it as failed", qj.ID.String())
+			log.Printf("Found %s %s and marked it as failed", description, qj.ID.String())
 		} else {
 			// We don't want to return an error here since there may easily be
 			// race/idempotence errors with a stuck job watcher. If it errors
 			// we'll grab it with the next cron.
-			log.Printf("Found stuck job %s but could not process it: %s", qj.ID.String(), err.Error())
+			log.Printf("Found %s %s but could not process it: %s", description, qj.ID.String(), err.Error())
 		}
 	}
-	return nil
 }
 
 // WatchStuckJobs polls the queued_jobs table for stuck jobs (defined as
 // in-progress jobs that haven't been updated in oldDuration time), and marks
 // them as failed.
 func WatchStuckJobs(interval time.Duration, olderThan time.Duration) {
-	for _ = range time.Tick(interval) {
+	WatchStuckJobsContext(context.Background(), interval, olderThan)
+}
+
+// WatchStuckJobsContext is like WatchStuckJobs, but returns once ctx is
+// cancelled.
+func WatchStuckJobsContext(ctx context.Context, interval time.Duration, olderThan time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		go func() {
 			err := ArchiveStuckJobs(olderThan)
 			if err != nil {
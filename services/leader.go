@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models/db"
+)
+
+// LeaderLockID is the key of the Postgres advisory lock held by the leader.
+var LeaderLockID int64 = 0x7269636b6f766572 // "rickover"
+
+// A Leader runs tasks that should only run in one process at a time, like
+// sweeping for stuck jobs or measuring the queue depth. Every process
+// campaigns for leadership by trying to take a Postgres advisory lock; the
+// process holding the lock runs the tasks.
+//
+// The lock is tied to a database connection, so if the leader exits or loses
+// its connection, Postgres releases the lock and another process takes over
+// within Interval.
+type Leader struct {
+	// LockID is the advisory lock key. Processes that use the same key elect
+	// one leader between them.
+	LockID int64
+
+	// Interval is how often a follower tries to take the lock, and how often
+	// the leader checks that its connection is still alive.
+	Interval time.Duration
+
+	mu     sync.Mutex
+	tasks  []func(context.Context)
+	leader bool
+}
+
+// NewLeader creates a Leader that uses LeaderLockID and checks the lock every
+// five seconds.
+func NewLeader() *Leader {
+	return &Leader{
+		LockID:   LeaderLockID,
+		Interval: 5 * time.Second,
+	}
+}
+
+// Go adds a task to run while this process is the leader. The task is
+// started every time this process is elected, and should return once ctx is
+// cancelled. Tasks must be added before Run is called.
+func (l *Leader) Go(task func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tasks = append(l.tasks, task)
+}
+
+// IsLeader reports whether this process is currently the leader.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+func (l *Leader) setLeader(leader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leader = leader
+}
+
+// Run campaigns for leadership until ctx is cancelled, running the tasks
+// whenever this process is the leader. When ctx is cancelled, Run stops the
+// tasks, releases the lock and returns.
+func (l *Leader) Run(ctx context.Context) {
+	for {
+		conn, err := l.acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error acquiring leader lock: %s\n", err.Error())
+			go metrics.Increment("leader.acquire_error")
+		}
+		if conn != nil {
+			l.lead(ctx, conn)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.Interval):
+		}
+	}
+}
+
+// acquire returns a connection holding the leader lock, or nil if another
+// process holds it.
+func (l *Leader) acquire(ctx context.Context) (*sql.Conn, error) {
+	conn, err := db.Conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	err = conn.QueryRowContext(ctx, "-- services.Leader.acquire\nSELECT pg_try_advisory_lock($1)", l.LockID).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// lead runs the tasks until ctx is cancelled or conn stops working, then
+// releases the lock.
+func (l *Leader) lead(ctx context.Context, conn *sql.Conn) {
+	defer conn.Close()
+	l.mu.Lock()
+	tasks := make([]func(context.Context), len(l.tasks))
+	copy(tasks, l.tasks)
+	l.mu.Unlock()
+
+	l.setLeader(true)
+	log.Printf("Elected leader, running %d tasks\n", len(tasks))
+	go metrics.Increment("leader.elected")
+	taskCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task func(context.Context)) {
+			defer wg.Done()
+			task(taskCtx)
+		}(task)
+	}
+
+	ticker := time.NewTicker(l.Interval)
+	for lost := false; !lost; {
+		select {
+		case <-ctx.Done():
+			lost = true
+		case <-ticker.C:
+			var one int
+			if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Lost leader lock: %s\n", err.Error())
+					go metrics.Increment("leader.lost")
+				}
+				lost = true
+			}
+		}
+	}
+	ticker.Stop()
+	cancel()
+	wg.Wait()
+	l.setLeader(false)
+
+	// Release the lock so another process can take over right away. If the
+	// connection is broken this fails, but Postgres releases the lock when
+	// the session ends.
+	unlockCtx, unlockCancel := context.WithTimeout(context.Background(), l.Interval)
+	defer unlockCancel()
+	conn.ExecContext(unlockCtx, "-- services.Leader.lead\nSELECT pg_advisory_unlock($1)", l.LockID)
+}
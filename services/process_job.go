@@ -1,18 +1,20 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/Shyp/go-simple-metrics"
-	"github.com/Shyp/rest"
 	"github.com/Shyp/rickover/downstream"
 	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
 )
 
@@ -25,6 +27,10 @@ const defaultSleepFactor = 2
 // between 503 Service Unavailable downstream responses.
 var UnavailableSleepFactor = 500
 
+// MaxRetryAfter is the longest a job is put back in the queue for when the
+// downstream server responds with a Retry-After header.
+var MaxRetryAfter = 1 * time.Hour
+
 // DefaultTimeout is the default amount of time a JobProcessor should wait for
 // a job to complete, once it's been sent to the downstream server.
 var DefaultTimeout = 5 * time.Minute
@@ -42,6 +48,10 @@ type JobProcessor struct {
 	// to acquire a job. The formula for sleeps is 10 * (Factor) ^ (Attempts)
 	// ms. Set to 0 to not sleep between attempts.
 	SleepFactor float64
+
+	// Stops sending jobs of a type downstream after repeated failures. Set
+	// to nil to always send jobs.
+	Breaker *CircuitBreaker
 }
 
 // NewJobProcessor creates a services.JobProcessor that makes requests to the
@@ -53,11 +63,15 @@ type JobProcessor struct {
 // If the downstream server does not hit the callback, jobs sent to the
 // downstream server are timed out and marked as failed after DefaultTimeout
 // has elapsed.
+//
+// Each job type gets a CircuitBreaker with the default threshold and
+// cooldown.
 func NewJobProcessor(downstreamUrl string, downstreamPassword string) *JobProcessor {
 	return &JobProcessor{
 		Client:      downstream.NewClient("jobs", downstreamPassword, downstreamUrl),
 		Timeout:     DefaultTimeout,
 		SleepFactor: defaultSleepFactor,
+		Breaker:     NewCircuitBreaker(),
 	}
 }
 
@@ -68,19 +82,96 @@ func isTimeout(err error) bool {
 }
 
 // DoWork sends the given queued job to the downstream service, then waits for
-// it to complete.
+// it to complete. If the job type's circuit breaker is open, the job is put
+// back in the queue without using up an attempt.
+//
+// If the downstream server responds with a 429 or 503 and a Retry-After
+// header, the job is put back in the queue until then, without using up an
+// attempt. Other 4xx responses fail the job without retrying it, and 5xx
+// responses fail the job and retry it.
+//
+// If the job type is synchronous and the downstream server responds with a
+// 200 and the job's status, the job is archived or retried straight away,
+// instead of waiting for a callback.
 func (jp *JobProcessor) DoWork(qj *models.QueuedJob) error {
-	if err := jp.requestRetry(qj); err != nil {
+	return jp.DoWorkContext(context.Background(), qj)
+}
+
+// DoWorkContext is like DoWork, but stops sending the job or waiting for it to
+// complete if ctx is cancelled, and returns ctx.Err(). If the downstream
+// server hadn't accepted the job yet, it's released back to the queue without
+// using up an attempt. Otherwise it's left in progress, since the downstream
+// server should still make a callback.
+func (jp *JobProcessor) DoWorkContext(ctx context.Context, qj *models.QueuedJob) error {
+	ok, probe := jp.Breaker.Allow(qj.Name)
+	if !ok {
+		return queued_jobs.Release(qj.ID)
+	}
+	result, err := jp.requestRetry(ctx, qj, probe)
+	if err != nil {
+		if ctx.Err() != nil {
+			return releaseCancelledJob(ctx, qj)
+		}
 		if isTimeout(err) {
 			// Assume the request made it to Heroku; we see this most often
 			// when the downstream server restarts. Heroku receives/queues the
 			// requests until the new server is ready, and we see a timeout.
-			return waitForJob(qj, jp.Timeout)
-		} else {
-			return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true)
+			recordAccepted(qj)
+			return waitForJob(ctx, qj, jp.Timeout)
 		}
+		if derr, ok := err.(*downstream.Error); ok {
+			switch classifyError(derr) {
+			case errorClassRetryAfter:
+				wait := derr.RetryAfter
+				if wait > MaxRetryAfter {
+					wait = MaxRetryAfter
+				}
+				log.Printf("downstream server asked to retry job %s (type %s) after %v", qj.ID.String(), qj.Name, wait)
+				return queued_jobs.ReleaseAt(qj.ID, time.Now().Add(wait))
+			case errorClassClient:
+				return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, false)
+			}
+		}
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true)
+	}
+	if result != nil {
+		if done, err := finishSynchronousJob(qj, result); done {
+			return err
+		}
+	}
+	recordAccepted(qj)
+	return waitForJob(ctx, qj, jp.Timeout)
+}
+
+// recordAccepted records that the downstream server has qj, so the job isn't
+// failed if this process dies while it's waiting for the callback.
+func recordAccepted(qj *models.QueuedJob) {
+	if err := queued_jobs.Accepted(qj.ID); err != nil {
+		log.Printf("Error recording that job %s (type %s) was accepted: %s", qj.ID.String(), qj.Name, err.Error())
+	}
+}
+
+const (
+	errorClassRetryAfter = "retry_after"
+	errorClassClient     = "client_error"
+	errorClassServer     = "server_error"
+)
+
+// classifyError returns errorClassRetryAfter if the downstream server asked us
+// to try again later, errorClassClient if it rejected the job, and
+// errorClassServer if the job might succeed if it's retried.
+func classifyError(err *downstream.Error) string {
+	switch {
+	case err.RetryAfter > 0 && (err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusServiceUnavailable):
+		return errorClassRetryAfter
+	case err.StatusCode == http.StatusTooManyRequests:
+		// Being rate limited says nothing about whether the job is valid.
+		return errorClassServer
+	case err.StatusCode >= 400 && err.StatusCode < 500:
+		return errorClassClient
+	default:
+		return errorClassServer
 	}
-	return waitForJob(qj, jp.Timeout)
 }
 
 // Jitter returns a value that's around the given val, but not exactly it. The
@@ -91,40 +182,63 @@ func jitter(val float64) float64 {
 }
 
 func (jp JobProcessor) Sleep(failedAttempts uint32) time.Duration {
-	multiplier := math.Pow(jp.SleepFactor, float64(failedAttempts))
+	return sleepFor(jp.SleepFactor, failedAttempts)
+}
+
+// sleepFor returns 10 * factor ^ failedAttempts milliseconds, with jitter,
+// capped at about 10 seconds. Workers use it to decide how long to sleep
+// between failed attempts to acquire a job.
+func sleepFor(factor float64, failedAttempts uint32) time.Duration {
+	multiplier := math.Pow(factor, float64(failedAttempts))
 	if multiplier > maxMultiplier {
 		multiplier = maxMultiplier
 	}
 	return 10 * time.Duration(jitter(multiplier)) * time.Millisecond
 }
 
-func (jp *JobProcessor) requestRetry(qj *models.QueuedJob) error {
+func (jp *JobProcessor) requestRetry(ctx context.Context, qj *models.QueuedJob, probe bool) (*downstream.JobResult, error) {
 	log.Printf("processing job %s (type %s)", qj.ID.String(), qj.Name)
+	var lastErr error
 	for i := uint8(0); i < 3; i++ {
 		if qj.ExpiresAt.Valid && time.Since(qj.ExpiresAt.Time) >= 0 {
-			return createAndDelete(qj.ID, qj.Name, models.StatusExpired, qj.Attempts)
+			return nil, createAndDelete(qj.ID, qj.Name, models.StatusExpired, qj.Attempts)
 		}
 		params := &downstream.JobParams{
 			Data:     qj.Data,
 			Attempts: qj.Attempts,
 		}
 		start := time.Now()
-		err := jp.Client.Job.Post(qj.Name, &qj.ID, params)
+		result, err := jp.Client.Job.PostResult(ctx, qj.Name, &qj.ID, params)
+		// If the request succeeded, the downstream server has the job, even
+		// if ctx was cancelled at the same time.
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		go metrics.Time("post_job.latency", time.Since(start))
 		go metrics.Time(fmt.Sprintf("post_job.%s.latency", qj.Name), time.Since(start))
+		jp.Breaker.Record(qj.Name, err, probe)
+		recordDownstreamRequest(qj.Name, time.Since(start), err)
 		if err == nil {
 			go metrics.Increment(fmt.Sprintf("post_job.%s.accepted", qj.Name))
-			return nil
+			return result, nil
 		} else {
 			switch aerr := err.(type) {
-			case *rest.Error:
-				if aerr.ID == "service_unavailable" {
+			case *downstream.Error:
+				class := classifyError(aerr)
+				if class == errorClassServer && (aerr.Err.ID == "service_unavailable" || aerr.StatusCode == http.StatusServiceUnavailable) {
 					go metrics.Increment("post_job.unavailable")
-					time.Sleep(time.Duration(1<<i*UnavailableSleepFactor) * time.Millisecond)
+					lastErr = err
+					select {
+					case <-time.After(time.Duration(1<<i*UnavailableSleepFactor) * time.Millisecond):
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
 					continue
 				}
 				go metrics.Increment("dequeue.post_job.error")
-				return err
+				go metrics.Increment(fmt.Sprintf("post_job.%s", class))
+				go metrics.Increment(fmt.Sprintf("post_job.%s.%s", qj.Name, class))
+				return nil, err
 			default:
 				go func(err error) {
 					if isTimeout(err) {
@@ -134,14 +248,61 @@ func (jp *JobProcessor) requestRetry(qj *models.QueuedJob) error {
 						metrics.Increment("dequeue.post_job.error_unknown")
 					}
 				}(err)
-				return err
+				return nil, err
 			}
 		}
 	}
-	return nil
+	// The downstream server was unavailable every time, so the job failed.
+	return nil, lastErr
+}
+
+// releaseCancelledJob puts qj back in the queue, without using up an attempt,
+// because ctx was cancelled before the job could be finished. It returns
+// ctx.Err(), unless the job couldn't be released.
+func releaseCancelledJob(ctx context.Context, qj *models.QueuedJob) error {
+	err := queued_jobs.Release(qj.ID)
+	if err == queued_jobs.ErrNotFound {
+		// Already archived or retried by someone else.
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Could not release job %s (type %s): %s", qj.ID.String(), qj.Name, err.Error())
+		go metrics.Increment(fmt.Sprintf("dequeue.%s.release.error", qj.Name))
+		return err
+	}
+	log.Printf("Released job %s (type %s) back to the queue: %s", qj.ID.String(), qj.Name, ctx.Err())
+	go metrics.Increment(fmt.Sprintf("dequeue.%s.released", qj.Name))
+	return ctx.Err()
+}
+
+// finishSynchronousJob archives or retries qj using the result in the
+// downstream server's response, and returns true, if the job type is
+// synchronous. Otherwise it returns false, and the result should come from a
+// status callback.
+func finishSynchronousJob(qj *models.QueuedJob, result *downstream.JobResult) (bool, error) {
+	job, err := jobs.GetCached(qj.Name)
+	if err != nil {
+		log.Printf("Could not get job type %s: %s", qj.Name, err.Error())
+		return false, nil
+	}
+	if !job.Synchronous {
+		return false, nil
+	}
+	status := models.JobStatus(result.Status)
+	if status != models.StatusSucceeded && status != models.StatusFailed {
+		log.Printf("Invalid status %q in response for job %s (type %s), waiting for callback", result.Status, qj.ID.String(), qj.Name)
+		go metrics.Increment(fmt.Sprintf("post_job.%s.synchronous.invalid_status", qj.Name))
+		return false, nil
+	}
+	retryable := true
+	if result.Retryable != nil {
+		retryable = *result.Retryable
+	}
+	go metrics.Increment(fmt.Sprintf("post_job.%s.synchronous", qj.Name))
+	return true, HandleStatusCallbackResult(qj.ID, qj.Name, status, qj.Attempts, retryable, result.Result)
 }
 
-func waitForJob(qj *models.QueuedJob, failTimeout time.Duration) error {
+func waitForJob(ctx context.Context, qj *models.QueuedJob, failTimeout time.Duration) error {
 	start := time.Now()
 	// This is not going to change but we continually overwrite qj
 	name := qj.Name
@@ -155,6 +316,10 @@ func waitForJob(qj *models.QueuedJob, failTimeout time.Duration) error {
 	timeoutChan := time.After(failTimeout)
 	for {
 		select {
+		case <-ctx.Done():
+			go metrics.Increment(fmt.Sprintf("wait_for_job.%s.cancelled", name))
+			log.Printf("stopped waiting for job %s (type %s) after %v: %s", idStr, name, time.Since(start), ctx.Err())
+			return ctx.Err()
 		case <-timeoutChan:
 			go metrics.Increment(fmt.Sprintf("wait_for_job.%s.timeout", name))
 			log.Printf("5 minutes elapsed, marking %s (type %s) as failed", idStr, name)
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rest"
+	"github.com/Shyp/rickover/downstream"
+	"github.com/Shyp/rickover/test"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		code       int
+		retryAfter time.Duration
+		class      string
+	}{
+		{http.StatusTooManyRequests, 5 * time.Second, errorClassRetryAfter},
+		{http.StatusServiceUnavailable, 5 * time.Second, errorClassRetryAfter},
+		{http.StatusTooManyRequests, 0, errorClassServer},
+		{http.StatusServiceUnavailable, 0, errorClassServer},
+		{http.StatusInternalServerError, 5 * time.Second, errorClassServer},
+		{http.StatusBadRequest, 0, errorClassClient},
+		{http.StatusNotFound, 5 * time.Second, errorClassClient},
+	}
+	for _, tt := range tests {
+		err := &downstream.Error{
+			StatusCode: tt.code,
+			Err:        &rest.Error{Title: http.StatusText(tt.code)},
+			RetryAfter: tt.retryAfter,
+		}
+		test.AssertEquals(t, classifyError(err), tt.class)
+	}
+}
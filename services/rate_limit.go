@@ -0,0 +1,49 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models/rate_limits"
+)
+
+// MaxRateLimitWait is the longest a dequeuer sleeps between attempts to take a
+// token from a job type's rate limit bucket.
+var MaxRateLimitWait = 5 * time.Second
+
+// TakeRateLimitToken takes a token from the named job type's rate limit
+// bucket, so a dequeuer can acquire a job and send it downstream. If there
+// isn't one, ok is false, and wait is how long to sleep before trying again.
+// If the bucket can't be read, the job is let through, since holding it up
+// wouldn't help.
+//
+// Dequeuers take a token before they acquire a job, so throttled jobs stay
+// queued instead of sitting in progress.
+func TakeRateLimitToken(name string) (ok bool, wait time.Duration) {
+	ok, wait, err := rate_limits.TakeToken(name)
+	if err != nil {
+		log.Printf("Could not check rate limit for %s: %s", name, err.Error())
+		go metrics.Increment("rate_limit.error")
+		return true, 0
+	}
+	if ok {
+		return true, 0
+	}
+	go metrics.Increment(fmt.Sprintf("rate_limit.%s.throttled", name))
+	if wait > MaxRateLimitWait {
+		wait = MaxRateLimitWait
+	}
+	// Spread out workers that were throttled at the same time.
+	return false, time.Duration(jitter(float64(wait)))
+}
+
+// ReturnRateLimitToken gives back a token taken by TakeRateLimitToken when
+// there was no job to acquire.
+func ReturnRateLimitToken(name string) {
+	if err := rate_limits.ReturnToken(name); err != nil {
+		log.Printf("Could not return rate limit token for %s: %s", name, err.Error())
+		go metrics.Increment("rate_limit.error")
+	}
+}
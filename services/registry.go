@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/rickover/models"
+)
+
+// A Handler does the work for a queued job in process. Return nil if the job
+// succeeded. If it returns an error the job is retried, unless the error is
+// wrapped with Permanent.
+//
+// ctx is cancelled when the Registry's Timeout elapses; handlers should stop
+// work when that happens. The job isn't retried until the Handler returns,
+// so a Handler that ignores ctx holds up its job and its dequeuer.
+type Handler func(ctx context.Context, qj *models.QueuedJob) error
+
+// ErrHandlerTimeout is reported when a Handler doesn't return before the
+// Registry's Timeout.
+var ErrHandlerTimeout = errors.New("Handler timed out")
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+// Permanent wraps err to indicate that the job failed and shouldn't be
+// retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if err was returned by Permanent. Errors that wrap
+// the result of Permanent aren't permanent, so return it from the Handler
+// as is.
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+// A Registry is a Worker that runs jobs with Go functions in the same process,
+// instead of sending them to a downstream server. Register a Handler for each
+// job type with Handle; the job's status is recorded with
+// HandleStatusCallback when the Handler returns.
+//
+// A Registry is safe for use by multiple goroutines.
+type Registry struct {
+	// Amount of time a Handler has to finish a job before its context is
+	// cancelled. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// Multiplier used to determine how long to sleep between failed attempts
+	// to acquire a job. The formula for sleeps is 10 * (Factor) ^ (Attempts)
+	// ms. Set to 0 to not sleep between attempts.
+	SleepFactor float64
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		Timeout:     DefaultTimeout,
+		SleepFactor: defaultSleepFactor,
+		handlers:    make(map[string]Handler),
+	}
+}
+
+// Handle registers h to run jobs of the given type. Registering a handler for
+// a job type that already has one replaces it.
+func (r *Registry) Handle(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string]Handler)
+	}
+	r.handlers[name] = h
+}
+
+func (r *Registry) handler(name string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// DoWork runs the Handler registered for the job's type, and records whether
+// the job succeeded or failed. Jobs whose Handler panics or times out are
+// failed and retried. Jobs without a registered Handler are failed without
+// being retried, since retrying them won't help.
+func (r *Registry) DoWork(qj *models.QueuedJob) error {
+	return r.DoWorkContext(context.Background(), qj)
+}
+
+// DoWorkContext is like DoWork, but the Handler's context is cancelled when
+// ctx is. If that happens before the Handler returns, the job is released
+// back to the queue without using up an attempt, and DoWorkContext returns
+// ctx.Err().
+func (r *Registry) DoWorkContext(ctx context.Context, qj *models.QueuedJob) error {
+	if qj.ExpiresAt.Valid && time.Since(qj.ExpiresAt.Time) >= 0 {
+		return createAndDelete(qj.ID, qj.Name, models.StatusExpired, qj.Attempts)
+	}
+	h, ok := r.handler(qj.Name)
+	if !ok {
+		log.Printf("No handler registered for job %s (type %s)", qj.ID.String(), qj.Name)
+		go metrics.Increment(fmt.Sprintf("registry.%s.no_handler", qj.Name))
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, false)
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	start := time.Now()
+	err := runHandler(ctx, h, qj, timeout)
+	if ctx.Err() != nil && (err == ctx.Err() || err == ErrHandlerTimeout) {
+		return releaseCancelledJob(ctx, qj)
+	}
+	go metrics.Time(fmt.Sprintf("registry.%s.latency", qj.Name), time.Since(start))
+	if err == nil {
+		go metrics.Increment(fmt.Sprintf("registry.%s.succeeded", qj.Name))
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, qj.Attempts, true)
+	}
+	log.Printf("job %s (type %s) failed: %s", qj.ID.String(), qj.Name, err.Error())
+	if IsPermanent(err) {
+		go metrics.Increment(fmt.Sprintf("registry.%s.failed.permanent", qj.Name))
+		return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, false)
+	}
+	go metrics.Increment(fmt.Sprintf("registry.%s.failed", qj.Name))
+	return HandleStatusCallback(qj.ID, qj.Name, models.StatusFailed, qj.Attempts, true)
+}
+
+// runHandler runs h, and converts a panic into an error. If h doesn't return
+// within timeout, or before parent is cancelled, its context is cancelled
+// and ErrHandlerTimeout is returned once it does return, so the job can't be
+// retried while h is still running.
+func runHandler(parent context.Context, h Handler, qj *models.QueuedJob, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic running job %s (type %s): %v\n%s", qj.ID.String(), qj.Name, p, debug.Stack())
+			go metrics.Increment(fmt.Sprintf("registry.%s.panic", qj.Name))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	err = h(ctx, qj)
+	if ctx.Err() != nil {
+		if parent.Err() == nil {
+			go metrics.Increment(fmt.Sprintf("registry.%s.timeout", qj.Name))
+		}
+		return ErrHandlerTimeout
+	}
+	return err
+}
+
+// Sleep returns the amount of time to sleep between failed attempts to
+// acquire a job.
+func (r *Registry) Sleep(failedAttempts uint32) time.Duration {
+	return sleepFor(r.SleepFactor, failedAttempts)
+}
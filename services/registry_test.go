@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/test"
+)
+
+func testQueuedJob() *models.QueuedJob {
+	id, _ := types.NewPrefixUUID("job_6740b44e-13b9-475d-af06-979627e0e0d6")
+	return &models.QueuedJob{ID: id, Name: "echo"}
+}
+
+func TestPermanent(t *testing.T) {
+	t.Parallel()
+	test.AssertEquals(t, Permanent(nil), nil)
+	err := Permanent(errors.New("invalid address"))
+	test.AssertEquals(t, IsPermanent(err), true)
+	test.AssertEquals(t, err.Error(), "invalid address")
+	test.AssertEquals(t, IsPermanent(errors.New("invalid address")), false)
+	test.AssertEquals(t, IsPermanent(nil), false)
+}
+
+func TestSleepFor(t *testing.T) {
+	t.Parallel()
+	test.AssertEquals(t, sleepFor(0, 3), time.Duration(0))
+	d := sleepFor(2, 3)
+	test.AssertBetween(t, int64(d), int64(60*time.Millisecond), int64(100*time.Millisecond))
+	d = sleepFor(2, 100)
+	test.AssertBetween(t, int64(d), int64(8*time.Second), int64(13*time.Second))
+}
+
+func TestRunHandlerRecoversPanic(t *testing.T) {
+	t.Parallel()
+	err := runHandler(context.Background(), func(ctx context.Context, qj *models.QueuedJob) error {
+		panic("oh no")
+	}, testQueuedJob(), time.Second)
+	test.AssertError(t, err, "")
+	test.AssertEquals(t, err.Error(), "panic: oh no")
+	test.AssertEquals(t, IsPermanent(err), false)
+}
+
+func TestRunHandlerTimeout(t *testing.T) {
+	t.Parallel()
+	finished := false
+	err := runHandler(context.Background(), func(ctx context.Context, qj *models.QueuedJob) error {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+		return nil
+	}, testQueuedJob(), 5*time.Millisecond)
+	test.AssertEquals(t, err, ErrHandlerTimeout)
+	// The job isn't retried while the handler is still running.
+	test.AssertEquals(t, finished, true)
+}
+
+func TestRunHandlerReturnsError(t *testing.T) {
+	t.Parallel()
+	err := runHandler(context.Background(), func(ctx context.Context, qj *models.QueuedJob) error {
+		return Permanent(errors.New("bad data"))
+	}, testQueuedJob(), time.Second)
+	test.AssertEquals(t, IsPermanent(err), true)
+}
+
+func TestHandleReplacesHandler(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	_, ok := r.handler("echo")
+	test.AssertEquals(t, ok, false)
+	r.Handle("echo", func(ctx context.Context, qj *models.QueuedJob) error { return nil })
+	r.Handle("echo", func(ctx context.Context, qj *models.QueuedJob) error { return errors.New("second") })
+	h, ok := r.handler("echo")
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, h(context.Background(), nil).Error(), "second")
+}
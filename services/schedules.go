@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/Shyp/go-dberror"
+	"github.com/Shyp/go-simple-metrics"
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/cron"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/schedules"
+	"github.com/nu7hatch/gouuid"
+)
+
+// DueScheduleLimit is the maximum number of schedules to process in each
+// iteration of the schedule watcher.
+var DueScheduleLimit = 100
+
+// scheduleNamespace is used to derive job IDs from a schedule name and tick
+// time, so every process enqueues the same ID for the same tick.
+var scheduleNamespace, _ = uuid.ParseHex("269abadf-86ab-4b9d-8fdc-e170200d5d9c")
+
+// ScheduleData is passed to a schedule's data template when rendering the data
+// for a job.
+type ScheduleData struct {
+	// Name is the name of the schedule.
+	Name string
+	// ScheduledAt is the tick time in the schedule's time zone.
+	ScheduledAt time.Time
+}
+
+// NextScheduledRun returns the first time after the given time that matches
+// cronExpr in the given time zone.
+func NextScheduledRun(cronExpr string, timeZone string, after time.Time) (time.Time, error) {
+	sched, loc, err := parseSchedule(cronExpr, timeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return nextRun(sched, loc, cronExpr, after)
+}
+
+// parseSchedule parses cronExpr and loads timeZone, so a schedule's ticks can
+// be computed without parsing them again for every tick.
+func parseSchedule(cronExpr string, timeZone string) (*cron.Schedule, *time.Location, error) {
+	sched, err := cron.Parse(cronExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sched, loc, nil
+}
+
+func nextRun(sched *cron.Schedule, loc *time.Location, cronExpr string, after time.Time) (time.Time, error) {
+	next := sched.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("Cron expression %q never matches", cronExpr)
+	}
+	return next, nil
+}
+
+// RenderScheduleData executes a schedule's data template for the given tick,
+// and returns an error if the result isn't valid JSON.
+func RenderScheduleData(s *models.Schedule, scheduledAt time.Time) (json.RawMessage, error) {
+	tmpl, err := template.New(s.Name).Option("missingkey=error").Parse(s.DataTemplate)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, ScheduleData{Name: s.Name, ScheduledAt: scheduledAt.In(loc)})
+	if err != nil {
+		return nil, err
+	}
+	var data json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
+		return nil, errors.New("Data template did not render valid JSON")
+	}
+	return data, nil
+}
+
+// ScheduledJobID returns the ID of the job enqueued by the named schedule for
+// the given tick. The same name and tick always return the same ID.
+func ScheduledJobID(name string, scheduledAt time.Time) (types.PrefixUUID, error) {
+	key := fmt.Sprintf("%s\n%s", name, scheduledAt.UTC().Format(time.RFC3339Nano))
+	u, err := uuid.NewV5(scheduleNamespace, []byte(key))
+	if err != nil {
+		return types.PrefixUUID{}, err
+	}
+	return types.PrefixUUID{Prefix: queued_jobs.Prefix, UUID: u}, nil
+}
+
+// enqueueScheduledJob enqueues the job for a single tick. It's not an error
+// if another process already enqueued the job, or it's already finished.
+//
+// A data template that can't be rendered won't render the next time either,
+// so the error is recorded on the schedule and the tick is skipped, instead
+// of blocking the schedule.
+func enqueueScheduledJob(s *models.Schedule, scheduledAt time.Time) error {
+	id, err := ScheduledJobID(s.Name, scheduledAt)
+	if err != nil {
+		return err
+	}
+	data, err := RenderScheduleData(s, scheduledAt)
+	if err != nil {
+		log.Printf("Skipping run of schedule %s at %s: %s", s.Name, scheduledAt.Format(time.RFC3339), err.Error())
+		go metrics.Increment("schedule.render.error")
+		return schedules.SetError(s.Name, err.Error())
+	}
+	_, err = queued_jobs.Enqueue(id, s.JobName, scheduledAt, types.NullTime{Valid: false}, data)
+	switch terr := err.(type) {
+	case nil:
+		go metrics.Increment("schedule.enqueue.success")
+		return nil
+	case *queued_jobs.UnknownOrArchivedError:
+		return nil
+	case *dberror.Error:
+		if terr.Code == dberror.CodeUniqueViolation {
+			return nil
+		}
+	}
+	return err
+}
+
+// dueTicks returns the most recent s.MaxCatchUp ticks of the schedule up to
+// now, oldest first, and the number of older ticks that were missed.
+func dueTicks(s *models.Schedule, now time.Time) ([]time.Time, int, error) {
+	maxCatchUp := s.MaxCatchUp
+	if maxCatchUp < 1 {
+		maxCatchUp = 1
+	}
+	sched, loc, err := parseSchedule(s.Cron, s.TimeZone)
+	if err != nil {
+		return nil, 0, err
+	}
+	var due []time.Time
+	skipped := 0
+	for scheduledAt := s.NextRunAt; !scheduledAt.After(now); {
+		if len(due) == maxCatchUp {
+			due = append(due[:0], due[1:]...)
+			skipped++
+		}
+		due = append(due, scheduledAt)
+		next, err := nextRun(sched, loc, s.Cron, scheduledAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		scheduledAt = next
+	}
+	return due, skipped, nil
+}
+
+// ProcessSchedule enqueues a job for each tick of the schedule up to now, and
+// advances the schedule's next_run_at past now. If the scheduler has fallen
+// behind, only the most recent s.MaxCatchUp ticks are enqueued.
+//
+// If another process advances the schedule first, ProcessSchedule stops and
+// returns nil; job IDs are derived from the tick time, so a tick enqueued by
+// both processes only runs once.
+func ProcessSchedule(s *models.Schedule, now time.Time) error {
+	due, skipped, err := dueTicks(s, now)
+	if err != nil || len(due) == 0 {
+		return err
+	}
+	sched, loc, err := parseSchedule(s.Cron, s.TimeZone)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		advanced, err := schedules.Advance(s.Name, s.NextRunAt, due[0])
+		if err != nil || !advanced {
+			return err
+		}
+		log.Printf("Schedule %s missed %d runs, skipping them", s.Name, skipped)
+		go metrics.Measure("schedule.skipped", int64(skipped))
+	}
+	for _, scheduledAt := range due {
+		if err := enqueueScheduledJob(s, scheduledAt); err != nil {
+			go metrics.Increment("schedule.enqueue.error")
+			return err
+		}
+		next, err := nextRun(sched, loc, s.Cron, scheduledAt)
+		if err != nil {
+			return err
+		}
+		advanced, err := schedules.Advance(s.Name, scheduledAt, next)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return nil
+		}
+	}
+	return nil
+}
+
+// RunDueSchedules enqueues jobs for every schedule that's due to run.
+func RunDueSchedules() error {
+	due, err := schedules.GetDue(DueScheduleLimit)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, s := range due {
+		if err := ProcessSchedule(s, now); err != nil {
+			// Keep going, so one bad schedule doesn't block the rest.
+			log.Printf("Error processing schedule %s: %s", s.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// WatchSchedules polls the schedules table for schedules that are due to run,
+// and enqueues their jobs.
+func WatchSchedules(interval time.Duration) {
+	WatchSchedulesContext(context.Background(), interval)
+}
+
+// WatchSchedulesContext is like WatchSchedules, but returns once ctx is
+// cancelled.
+func WatchSchedulesContext(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := RunDueSchedules()
+		if err != nil {
+			log.Printf("Error running schedules: %s\n", err.Error())
+		}
+	}
+}
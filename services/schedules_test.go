@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/test"
+)
+
+func TestScheduledJobIDIsDeterministic(t *testing.T) {
+	t.Parallel()
+	at := time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC)
+	id1, err := ScheduledJobID("nightly", at)
+	test.AssertNotError(t, err, "")
+	id2, err := ScheduledJobID("nightly", at.In(time.FixedZone("PST", -8*60*60)))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, id1.String(), id2.String())
+	test.AssertEquals(t, id1.Prefix, "job_")
+
+	id3, err := ScheduledJobID("nightly", at.Add(time.Minute))
+	test.AssertNotError(t, err, "")
+	test.AssertNotEquals(t, id1.String(), id3.String())
+	id4, err := ScheduledJobID("hourly", at)
+	test.AssertNotError(t, err, "")
+	test.AssertNotEquals(t, id1.String(), id4.String())
+}
+
+func TestRenderScheduleData(t *testing.T) {
+	t.Parallel()
+	s := &models.Schedule{
+		Name:         "nightly",
+		TimeZone:     "UTC",
+		DataTemplate: `{"schedule": "{{.Name}}", "date": "{{.ScheduledAt.Format "2006-01-02"}}"}`,
+	}
+	data, err := RenderScheduleData(s, time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, string(data), `{"schedule": "nightly", "date": "2016-01-01"}`)
+}
+
+func TestRenderScheduleDataInvalidJSON(t *testing.T) {
+	t.Parallel()
+	s := &models.Schedule{
+		Name:         "nightly",
+		TimeZone:     "UTC",
+		DataTemplate: `{"date": {{.ScheduledAt}}}`,
+	}
+	_, err := RenderScheduleData(s, time.Now())
+	test.AssertError(t, err, "")
+}
+
+func TestNextScheduledRunInvalidTimeZone(t *testing.T) {
+	t.Parallel()
+	_, err := NextScheduledRun("* * * * *", "Mars/Olympus_Mons", time.Now())
+	test.AssertError(t, err, "")
+}
+
+func TestDueTicksDefaultsToOne(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC)
+	s := &models.Schedule{Cron: "* * * * *", TimeZone: "UTC", NextRunAt: start}
+	due, skipped, err := dueTicks(s, start.Add(7*24*time.Hour))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(due), 1)
+	test.AssertEquals(t, due[0].Equal(start.Add(7*24*time.Hour)), true)
+	test.AssertEquals(t, skipped, 7*24*60)
+}
+
+func TestDueTicksMaxCatchUp(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC)
+	s := &models.Schedule{Cron: "* * * * *", TimeZone: "UTC", NextRunAt: start, MaxCatchUp: 3}
+	due, skipped, err := dueTicks(s, start.Add(10*time.Minute+30*time.Second))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(due), 3)
+	test.AssertEquals(t, due[0].Equal(start.Add(8*time.Minute)), true)
+	test.AssertEquals(t, due[2].Equal(start.Add(10*time.Minute)), true)
+	test.AssertEquals(t, skipped, 8)
+
+	due, skipped, err = dueTicks(s, start.Add(time.Minute))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(due), 2)
+	test.AssertEquals(t, skipped, 0)
+}
@@ -5,6 +5,8 @@
 package services
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -15,6 +17,7 @@ import (
 	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/db"
 	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
 )
@@ -29,8 +32,16 @@ import (
 // delete it, the number of attempts for the queued job don't match up with the
 // passed in value (slow)
 func HandleStatusCallback(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8, retryable bool) error {
+	return HandleStatusCallbackResult(id, name, status, attempt, retryable, nil)
+}
+
+// HandleStatusCallbackResult is like HandleStatusCallback, but also takes the
+// result reported by the downstream worker. If the job is archived and its
+// job type has an on_success or on_failure follow-up, the result is passed to
+// the follow-up job.
+func HandleStatusCallbackResult(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8, retryable bool, result json.RawMessage) error {
 	if status == models.StatusSucceeded {
-		err := createAndDelete(id, name, models.StatusSucceeded, attempt)
+		err := createAndDeleteResult(id, name, models.StatusSucceeded, attempt, result)
 		if err != nil {
 			go metrics.Increment("archived_job.create.success.error")
 		} else {
@@ -40,7 +51,7 @@ func HandleStatusCallback(id types.PrefixUUID, name string, status models.JobSta
 		}
 		return err
 	} else if status == models.StatusFailed {
-		err := handleFailedCallback(id, name, attempt, retryable)
+		err := handleFailedCallback(id, name, attempt, retryable, result)
 		if err != nil {
 			go metrics.Increment("archived_job.create.failed.error")
 		} else {
@@ -54,11 +65,85 @@ func HandleStatusCallback(id types.PrefixUUID, name string, status models.JobSta
 	}
 }
 
+// MaxFollowUpDepth is the longest chain of follow-up jobs that can be
+// enqueued from a single job. A job that has MaxFollowUpDepth ancestors is
+// archived without enqueueing its follow-up, so job types that follow up
+// with each other can't loop forever.
+var MaxFollowUpDepth = 10
+
+// FollowUpData is the data sent to a follow-up job enqueued by a job type's
+// on_success, on_failure or on_expire setting.
+type FollowUpData struct {
+	// ParentID is the ID of the job that triggered the follow-up.
+	ParentID types.PrefixUUID `json:"parent_id"`
+	// ParentName is the job type of the parent job.
+	ParentName string `json:"parent_name"`
+	// Status is the status the parent job was archived with.
+	Status models.JobStatus `json:"status"`
+	// Data is the parent job's data.
+	Data json.RawMessage `json:"data"`
+	// Result is the result the downstream worker reported for the parent
+	// job, or null.
+	Result json.RawMessage `json:"result"`
+}
+
 // createAndDelete creates an archived job, deletes the queued job, and returns
-// any errors.
+// any errors. Any blocked jobs that depend on the job are then unblocked or
+// failed, and if the job was the last one left in a closed batch, the batch
+// is completed.
 func createAndDelete(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8) error {
+	return createAndDeleteResult(id, name, status, attempt, nil)
+}
+
+// createAndDeleteResult is like createAndDelete, but if the job type has a
+// follow-up job for the given status, the follow-up is enqueued in the same
+// transaction that archives the job, with result in its data.
+func createAndDeleteResult(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8, result json.RawMessage) error {
+	job, err := jobs.GetCached(name)
+	if err == sql.ErrNoRows {
+		// No queued job can exist with an unknown name.
+		return queued_jobs.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Expiring isn't failing, so expired jobs only get an on_expire follow-up.
+	var followUp types.NullString
+	switch status {
+	case models.StatusSucceeded:
+		followUp = job.OnSuccess
+	case models.StatusFailed:
+		followUp = job.OnFailure
+	case models.StatusExpired:
+		followUp = job.OnExpire
+	}
+	var aj *models.ArchivedJob
+	if followUp.Valid {
+		aj, err = archiveWithFollowUp(id, name, status, attempt, followUp.String, result)
+	} else {
+		aj, err = archive(id, name, status, attempt)
+	}
+	if err != nil {
+		return err
+	}
+	// Jobs that were waiting on this one can now be run or failed.
+	resolveDependents(id)
+	if aj != nil && aj.BatchID != nil {
+		if err := completeBatch(*aj.BatchID); err != nil {
+			// The job has been archived, so don't return an error. The batch
+			// will be completed when it's closed again.
+			log.Printf("Could not complete batch %s: %s", aj.BatchID.String(), err.Error())
+			go metrics.Increment("batch.complete.error")
+		}
+	}
+	return nil
+}
+
+// archive archives the job and deletes the queued job. If another thread
+// already archived the job, the existing archived job is returned.
+func archive(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8) (*models.ArchivedJob, error) {
 	start := time.Now()
-	_, err := archived_jobs.Create(id, name, status, attempt)
+	aj, err := archived_jobs.Create(id, name, status, attempt)
 	go metrics.Time("archived_job.create.latency", time.Since(start))
 	if err != nil {
 		switch derr := err.(type) {
@@ -68,17 +153,92 @@ func createAndDelete(id types.PrefixUUID, name string, status models.JobStatus,
 				// fall through and try to delete the record.
 				log.Printf("Could not create archived job %s with status %s because "+
 					"it was already present. Deleting the queued job.", id.String(), status)
+				aj, err = archived_jobs.GetRetry(id, 3)
+				if err != nil {
+					return nil, err
+				}
 			} else {
-				return err
+				return nil, err
 			}
 		default:
-			return err
+			return nil, err
 		}
 	}
 	start = time.Now()
 	err = queued_jobs.DeleteRetry(id, 3)
 	go metrics.Time("queued_job.delete.latency", time.Since(start))
-	return err
+	return aj, err
+}
+
+// archiveWithFollowUp archives the job, deletes the queued job and enqueues
+// a job of type followUpName in a single transaction, so the follow-up is
+// enqueued exactly once.
+func archiveWithFollowUp(id types.PrefixUUID, name string, status models.JobStatus, attempt uint8, followUpName string, result json.RawMessage) (*models.ArchivedJob, error) {
+	start := time.Now()
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	aj, err := archived_jobs.CreateTx(tx, id, name, status, attempt)
+	if err != nil {
+		if derr, ok := err.(*dberror.Error); ok && derr.Code == dberror.CodeUniqueViolation {
+			// Some other thread archived the job, and enqueued the follow-up
+			// along with it. Delete the queued job, if it's still there.
+			log.Printf("Could not create archived job %s with status %s because "+
+				"it was already present. Deleting the queued job.", id.String(), status)
+			tx.Rollback()
+			aj, err = archived_jobs.GetRetry(id, 3)
+			if err != nil {
+				return nil, err
+			}
+			return aj, queued_jobs.DeleteRetry(id, 3)
+		}
+		return nil, err
+	}
+	if err := queued_jobs.DeleteTx(tx, id); err != nil {
+		return nil, err
+	}
+	if aj.ParentID != nil {
+		depth, err := archived_jobs.FollowUpDepthTx(tx, id, MaxFollowUpDepth)
+		if err != nil {
+			return nil, err
+		}
+		if depth >= MaxFollowUpDepth {
+			log.Printf("Job %s is %d follow-ups deep, not enqueueing its %s follow-up", id.String(), depth, followUpName)
+			go metrics.Increment("follow_up.too_deep")
+			if err := tx.Commit(); err != nil {
+				return nil, err
+			}
+			return aj, nil
+		}
+	}
+	followUpID, err := types.GenerateUUID(queued_jobs.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(FollowUpData{
+		ParentID:   id,
+		ParentName: name,
+		Status:     status,
+		Data:       aj.Data,
+		Result:     result,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = queued_jobs.EnqueueTx(tx, followUpID, followUpName, time.Now().UTC(), types.NullTime{Valid: false}, data, queued_jobs.EnqueueOptions{
+		ParentID: &id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	go metrics.Time("archived_job.create_with_follow_up.latency", time.Since(start))
+	go metrics.Increment(fmt.Sprintf("follow_up.%s.enqueued", followUpName))
+	return aj, nil
 }
 
 // getRunAfter gets the time this job should run after, given the current
@@ -88,17 +248,17 @@ func getRunAfter(totalAttempts, remainingAttempts uint8) time.Time {
 	return time.Now().UTC().Add(time.Duration(math.Pow(2, float64(backoff))) * time.Second)
 }
 
-func handleFailedCallback(id types.PrefixUUID, name string, attempt uint8, retryable bool) error {
+func handleFailedCallback(id types.PrefixUUID, name string, attempt uint8, retryable bool, result json.RawMessage) error {
 	remainingAttempts := attempt - 1
 	if retryable == false || remainingAttempts == 0 {
-		return createAndDelete(id, name, models.StatusFailed, remainingAttempts)
+		return createAndDeleteResult(id, name, models.StatusFailed, remainingAttempts, result)
 	}
-	job, err := jobs.GetRetry(name, 3)
+	job, err := jobs.GetCached(name)
 	if err != nil {
 		return err
 	}
 	if job.DeliveryStrategy == models.StrategyAtMostOnce {
-		return createAndDelete(id, name, models.StatusFailed, remainingAttempts)
+		return createAndDeleteResult(id, name, models.StatusFailed, remainingAttempts, result)
 	} else {
 		// Try the job again. Note the database decrements the attempt counter
 		start := time.Now()
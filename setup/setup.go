@@ -2,6 +2,7 @@
 package setup
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,9 +12,14 @@ import (
 	"github.com/Shyp/go-simple-metrics"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/batches"
+	"github.com/Shyp/rickover/models/circuit_breakers"
 	"github.com/Shyp/rickover/models/db"
 	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/rate_limits"
+	"github.com/Shyp/rickover/models/schedules"
+	"github.com/Shyp/rickover/models/workers"
 )
 
 var mu sync.Mutex
@@ -38,21 +44,47 @@ func GetActiveQueries() (count int64, err error) {
 	return
 }
 
+// every calls f every interval until ctx is cancelled.
+func every(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
 // TODO all of these should use a different database connection than the server
 // or the worker, to avoid contention.
 func MeasureActiveQueries(interval time.Duration) {
-	for _ = range time.Tick(interval) {
+	MeasureActiveQueriesContext(context.Background(), interval)
+}
+
+// MeasureActiveQueriesContext is like MeasureActiveQueries, but returns once
+// ctx is cancelled.
+func MeasureActiveQueriesContext(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func() {
 		count, err := GetActiveQueries()
 		if err == nil {
 			go metrics.Measure("active_queries.count", count)
 		} else {
 			go metrics.Increment("active_queries.error")
 		}
-	}
+	})
 }
 
 func MeasureQueueDepth(interval time.Duration) {
-	for _ = range time.Tick(interval) {
+	MeasureQueueDepthContext(context.Background(), interval)
+}
+
+// MeasureQueueDepthContext is like MeasureQueueDepth, but returns once ctx is
+// cancelled.
+func MeasureQueueDepthContext(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func() {
 		allCount, readyCount, err := queued_jobs.CountReadyAndAll()
 		if err == nil {
 			go metrics.Measure("queue_depth.all", int64(allCount))
@@ -60,11 +92,17 @@ func MeasureQueueDepth(interval time.Duration) {
 		} else {
 			go metrics.Increment("queue_depth.error")
 		}
-	}
+	})
 }
 
 func MeasureInProgressJobs(interval time.Duration) {
-	for _ = range time.Tick(interval) {
+	MeasureInProgressJobsContext(context.Background(), interval)
+}
+
+// MeasureInProgressJobsContext is like MeasureInProgressJobs, but returns
+// once ctx is cancelled.
+func MeasureInProgressJobsContext(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, func() {
 		m, err := queued_jobs.GetCountsByStatus(models.StatusInProgress)
 		if err == nil {
 			count := int64(0)
@@ -76,7 +114,7 @@ func MeasureInProgressJobs(interval time.Duration) {
 		} else {
 			go metrics.Increment("queued_jobs.in_progress.error")
 		}
-	}
+	})
 }
 
 // DB initializes a connection to the database, and prepares queries on all
@@ -111,6 +149,21 @@ func PrepareAll() error {
 	if err := archived_jobs.Setup(); err != nil {
 		return err
 	}
+	if err := schedules.Setup(); err != nil {
+		return err
+	}
+	if err := batches.Setup(); err != nil {
+		return err
+	}
+	if err := circuit_breakers.Setup(); err != nil {
+		return err
+	}
+	if err := workers.Setup(); err != nil {
+		return err
+	}
+	if err := rate_limits.Setup(); err != nil {
+		return err
+	}
 	if err := prepare(); err != nil {
 		return err
 	}
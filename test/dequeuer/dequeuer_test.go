@@ -1,6 +1,7 @@
 package dequeuer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/Shyp/rickover/dequeuer"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/queued_jobs"
 	"github.com/Shyp/rickover/test"
 	"github.com/Shyp/rickover/test/factory"
 )
@@ -149,3 +152,153 @@ func TestCreatePools(t *testing.T) {
 	}
 	test.Assert(t, foundPool, "Didn't create a pool for the job type")
 }
+
+func TestCreatePoolsWithOptions(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQJ(t)
+	other := factory.CreateQJ(t)
+	proc := factory.Processor("http://example.com")
+	pools, err := dequeuer.CreatePoolsWithOptions(proc, 0, dequeuer.PoolOptions{
+		Exclude:     []string{other.Name},
+		Concurrency: map[string]int{qj.Name: 5},
+	})
+	test.AssertNotError(t, err, "CreatePoolsWithOptions")
+	defer pools[0].Shutdown()
+	test.AssertEquals(t, len(pools), 1)
+	test.AssertEquals(t, pools[0].Name, qj.Name)
+	test.AssertEquals(t, len(pools[0].Dequeuers), 5)
+}
+
+type blockingWorker struct {
+	started   chan bool
+	cancelled chan bool
+}
+
+func (b *blockingWorker) DoWork(qj *models.QueuedJob) error {
+	panic("DoWork called on a ContextWorker")
+}
+
+func (b *blockingWorker) DoWorkContext(ctx context.Context, qj *models.QueuedJob) error {
+	b.started <- true
+	<-ctx.Done()
+	b.cancelled <- true
+	return ctx.Err()
+}
+
+func (b *blockingWorker) Sleep(failedAttempts uint32) time.Duration {
+	return 10 * time.Millisecond
+}
+
+func TestShutdownContextCancelsWork(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQJ(t)
+	w := &blockingWorker{started: make(chan bool, 1), cancelled: make(chan bool, 1)}
+	pool := dequeuer.NewPool(qj.Name)
+	pool.AddDequeuer(w)
+	select {
+	case <-w.started:
+	case <-time.After(time.Second):
+		t.Fatalf("worker did not start the job in 1s")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := pool.ShutdownContext(ctx)
+	test.AssertEquals(t, err, context.DeadlineExceeded)
+	select {
+	case <-w.cancelled:
+	default:
+		t.Fatalf("worker's context was not cancelled")
+	}
+}
+
+type panickingWorker struct {
+	calls chan *models.QueuedJob
+}
+
+func (p *panickingWorker) DoWork(qj *models.QueuedJob) error {
+	p.calls <- qj
+	panic("oh no")
+}
+
+func (p *panickingWorker) Sleep(failedAttempts uint32) time.Duration {
+	return 10 * time.Millisecond
+}
+
+func TestDequeuerRecoversFromPanic(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQJ(t)
+	w := &panickingWorker{calls: make(chan *models.QueuedJob, 2)}
+	pool := dequeuer.NewPool(qj.Name)
+	pool.AddDequeuer(w)
+	defer pool.Shutdown()
+	select {
+	case <-w.calls:
+	case <-time.After(time.Second):
+		t.Fatalf("worker did not start the job in 1s")
+	}
+	// The dequeuer should mark the job as failed, and keep dequeuing.
+	second := factory.CreateQueuedJobOnly(t, qj.Name, factory.EmptyData)
+	select {
+	case got := <-w.calls:
+		test.AssertEquals(t, got.ID.String(), second.ID.String())
+	case <-time.After(time.Second):
+		t.Fatalf("dequeuer stopped working after a panic")
+	}
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+}
+
+func TestPoolStatusInFlight(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQJ(t)
+	w := &blockingWorker{started: make(chan bool, 1), cancelled: make(chan bool, 1)}
+	pool := dequeuer.NewPool(qj.Name)
+	pool.AddDequeuer(w)
+	select {
+	case <-w.started:
+	case <-time.After(time.Second):
+		t.Fatalf("worker did not start the job in 1s")
+	}
+	status := pool.Status()
+	test.AssertEquals(t, status.Dequeuers, 1)
+	test.AssertEquals(t, len(status.InFlight), 1)
+	test.AssertEquals(t, status.InFlight[0].ID.String(), qj.ID.String())
+	test.AssertEquals(t, status.InFlight[0].DequeuerID, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool.ShutdownContext(ctx)
+	test.AssertEquals(t, len(pool.Status().InFlight), 0)
+}
+
+func TestPausedPoolDoesNotAcquire(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQJ(t)
+	w := &blockingWorker{started: make(chan bool, 1), cancelled: make(chan bool, 1)}
+	pool := dequeuer.NewPool(qj.Name)
+	pool.Pause()
+	pool.AddDequeuer(w)
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		pool.ShutdownContext(ctx)
+	}()
+	select {
+	case <-w.started:
+		t.Fatalf("paused pool acquired a job")
+	case <-time.After(100 * time.Millisecond):
+	}
+	pool.Resume()
+	select {
+	case <-w.started:
+	case <-time.After(time.Second):
+		t.Fatalf("worker did not start the job in 1s after resuming")
+	}
+}
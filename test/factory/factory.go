@@ -128,7 +128,7 @@ func CreateQJ(t testing.TB) *models.QueuedJob {
 func CreateArchivedJob(t *testing.T, data json.RawMessage, status models.JobStatus) *models.ArchivedJob {
 	t.Helper()
 	_, qj := createJobAndQueuedJob(t, SampleJob, data, false)
-	aj, err := archived_jobs.Create(qj.ID, qj.Name, models.StatusSucceeded, qj.Attempts)
+	aj, err := archived_jobs.Create(qj.ID, qj.Name, status, qj.Attempts)
 	test.AssertNotError(t, err, "")
 	err = queued_jobs.DeleteRetry(qj.ID, 3)
 	test.AssertNotError(t, err, "")
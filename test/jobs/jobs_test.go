@@ -1,14 +1,18 @@
 package test_jobs
 
 import (
+	"database/sql"
 	"fmt"
 	"testing"
 	"time"
 
 	types "github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
 	"github.com/Shyp/rickover/models/jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
 	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
 )
 
 func TestAll(t *testing.T) {
@@ -19,6 +23,8 @@ func TestAll(t *testing.T) {
 		t.Run("CreateInvalidFields", testCreateInvalidFields)
 		t.Run("CreateReturnsRecord", testCreateReturnsRecord)
 		t.Run("Get", testGet)
+		t.Run("CreateFollowUps", testCreateFollowUps)
+		t.Run("CreateUnknownFollowUp", testCreateUnknownFollowUp)
 	})
 }
 
@@ -88,3 +94,117 @@ func testGet(t *testing.T) {
 	diff := time.Since(j.CreatedAt)
 	test.Assert(t, diff < 100*time.Millisecond, "")
 }
+
+func testCreateFollowUps(t *testing.T) {
+	t.Parallel()
+	followUp := newJob(t)
+	_, err := jobs.Create(followUp)
+	test.AssertNotError(t, err, "")
+	j0 := newJob(t)
+	j0.OnSuccess = types.NullString{Valid: true, String: followUp.Name}
+	_, err = jobs.Create(j0)
+	test.AssertNotError(t, err, "")
+	j, err := jobs.Get(j0.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, j.OnSuccess.String, followUp.Name)
+	test.AssertEquals(t, j.OnFailure.Valid, false)
+}
+
+func testCreateUnknownFollowUp(t *testing.T) {
+	t.Parallel()
+	j0 := newJob(t)
+	j0.OnFailure = types.NullString{Valid: true, String: "unknown-job-type"}
+	_, err := jobs.Create(j0)
+	test.AssertError(t, err, "")
+}
+
+func TestPauseAndResume(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+
+	job, err := jobs.Pause(qj.Name, types.NullTime{Valid: false})
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Paused, true)
+	_, err = queued_jobs.Acquire(qj.Name)
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	job, err = jobs.Resume(qj.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Paused, false)
+	acquired, err := queued_jobs.Acquire(qj.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), qj.ID.String())
+}
+
+func TestPausedUntilExpires(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+
+	until := types.NullTime{Valid: true, Time: time.Now().Add(500 * time.Millisecond)}
+	job, err := jobs.Pause(qj.Name, until)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.PausedUntil.Valid, true)
+	_, err = queued_jobs.Acquire(qj.Name)
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	time.Sleep(600 * time.Millisecond)
+	_, err = queued_jobs.Acquire(qj.Name)
+	test.AssertNotError(t, err, "")
+}
+
+func TestPauseUnknownJobType(t *testing.T) {
+	test.SetUp(t)
+	_, err := jobs.Pause("unknown-job-type", types.NullTime{Valid: false})
+	test.AssertEquals(t, err, sql.ErrNoRows)
+}
+
+func TestGetCached(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	j := newJob(t)
+	// Unknown job types aren't cached.
+	_, err := jobs.GetCached(j.Name)
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	_, err = jobs.Create(j)
+	test.AssertNotError(t, err, "")
+	job, err := jobs.GetCached(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Name, j.Name)
+	test.AssertEquals(t, job.Attempts, j.Attempts)
+
+	_, err = jobs.Pause(j.Name, types.NullTime{Valid: false})
+	test.AssertNotError(t, err, "")
+	job, err = jobs.GetCached(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Paused, true)
+}
+
+func TestGetCachedIsStaleUntilCacheTTL(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	originalTTL := jobs.CacheTTL
+	jobs.CacheTTL = 100 * time.Millisecond
+	defer func() {
+		jobs.CacheTTL = originalTTL
+	}()
+	j := newJob(t)
+	_, err := jobs.Create(j)
+	test.AssertNotError(t, err, "")
+	_, err = jobs.GetCached(j.Name)
+	test.AssertNotError(t, err, "")
+
+	// Another process pausing the job type doesn't clear this one's cache.
+	_, err = db.Conn.Exec("UPDATE jobs SET paused = true WHERE name = $1", j.Name)
+	test.AssertNotError(t, err, "")
+	job, err := jobs.GetCached(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Paused, false)
+
+	time.Sleep(150 * time.Millisecond)
+	job, err = jobs.GetCached(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, job.Paused, true)
+}
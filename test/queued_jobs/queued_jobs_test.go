@@ -11,6 +11,7 @@ import (
 	"github.com/Shyp/go-dberror"
 	"github.com/Shyp/go-types"
 	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/circuit_breakers"
 	"github.com/Shyp/rickover/models/jobs"
 	"github.com/Shyp/rickover/models/queued_jobs"
 	"github.com/Shyp/rickover/services"
@@ -313,6 +314,33 @@ func TestCountAll(t *testing.T) {
 	test.AssertEquals(t, readyCount, 3)
 }
 
+func TestCountReady(t *testing.T) {
+	defer test.TearDown(t)
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	factory.CreateQueuedJobOnly(t, job.Name, factory.EmptyData)
+	factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	count, err := queued_jobs.CountReady(job.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 2)
+}
+
+func TestCountReadySkipsUnacquirableJobs(t *testing.T) {
+	defer test.TearDown(t)
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err := jobs.Pause(job.Name, types.NullTime{Valid: false})
+	test.AssertNotError(t, err, "")
+	count, err := queued_jobs.CountReady(job.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 0)
+
+	job, _ = factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err = circuit_breakers.RecordFailure(job.Name, 1, time.Hour)
+	test.AssertNotError(t, err, "")
+	count, err = queued_jobs.CountReady(job.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 0)
+}
+
 func TestCountByStatus(t *testing.T) {
 	defer test.TearDown(t)
 	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
@@ -346,3 +374,204 @@ func TestOldInProgress(t *testing.T) {
 	test.AssertNotError(t, err, "")
 	test.AssertEquals(t, len(jobs), 0)
 }
+
+func TestEnqueueDebouncedUpdatesQueuedJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+
+	expiresAt := types.NullTime{Valid: false}
+	runAfter := time.Now().UTC().Add(30 * time.Second)
+	qj, err := queued_jobs.EnqueueDebounced(factory.JobId, "echo", runAfter, expiresAt, empty, "usr_123")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.DebounceKey.String, "usr_123")
+
+	newData := json.RawMessage([]byte(`{"foo": "bar"}`))
+	laterRunAfter := runAfter.Add(time.Minute)
+	laterExpiresAt := types.NullTime{Valid: true, Time: laterRunAfter.Add(time.Hour)}
+	qj2, err := queued_jobs.EnqueueDebounced(factory.RandomId("job_"), "echo", laterRunAfter, laterExpiresAt, newData, "usr_123")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj2.ID.String(), qj.ID.String())
+	test.AssertEquals(t, string(qj2.Data), `{"foo": "bar"}`)
+	test.AssertEquals(t, qj2.ExpiresAt.Valid, true)
+	test.Assert(t, qj2.RunAfter.After(qj.RunAfter), "expected run_after to be pushed back")
+
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 1)
+}
+
+func TestEnqueueDebouncedAfterAcquireCreatesJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+
+	expiresAt := types.NullTime{Valid: false}
+	qj, err := queued_jobs.EnqueueDebounced(factory.JobId, "echo", time.Now().UTC(), expiresAt, empty, "usr_123")
+	test.AssertNotError(t, err, "")
+	acquired, err := queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), qj.ID.String())
+	test.AssertEquals(t, acquired.DebounceKey.Valid, false)
+
+	id := factory.RandomId("job_")
+	qj2, err := queued_jobs.EnqueueDebounced(id, "echo", time.Now().UTC(), expiresAt, empty, "usr_123")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj2.ID.String(), id.String())
+}
+
+func TestUpdateLeavesUnsetFields(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+	expiresAt := types.NullTime{Valid: true, Time: time.Now().UTC().Add(time.Hour)}
+	updated, err := queued_jobs.Update(qj.ID, qj.Name, types.NullTime{Valid: false}, expiresAt, nil)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, string(updated.Data), string(qj.Data))
+	test.AssertEquals(t, updated.RunAfter.Equal(qj.RunAfter), true)
+	test.AssertEquals(t, updated.ExpiresAt.Valid, true)
+}
+
+func TestUpdateWrongNameReturnsNotFound(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+	_, err := queued_jobs.Update(qj.ID, "unknown", types.NullTime{Valid: false}, types.NullTime{Valid: false}, empty)
+	test.AssertEquals(t, err, queued_jobs.ErrNotFound)
+}
+
+func TestUpdateBlockedJobReturnsErrBlocked(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	parent := factory.CreateQueuedJob(t, factory.EmptyData)
+	qj, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), parent.Name, time.Now().UTC(), types.NullTime{Valid: false}, empty, queued_jobs.EnqueueOptions{DependsOn: []types.PrefixUUID{parent.ID}})
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Update(qj.ID, qj.Name, types.NullTime{Valid: false}, types.NullTime{Valid: false}, empty)
+	test.AssertEquals(t, err, queued_jobs.ErrBlocked)
+}
+
+func TestAcquireSkipsJobsWithBusyConcurrencyKey(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+
+	expiresAt := types.NullTime{Valid: false}
+	runAfter := time.Now().UTC()
+	first, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", runAfter, expiresAt, empty, queued_jobs.EnqueueOptions{ConcurrencyKey: "acct_123", ConcurrencyLimit: 1})
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, first.ConcurrencyKey.String, "acct_123")
+	test.AssertEquals(t, first.ConcurrencyLimit, uint8(1))
+	_, err = queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", runAfter, expiresAt, empty, queued_jobs.EnqueueOptions{ConcurrencyKey: "acct_123", ConcurrencyLimit: 1})
+	test.AssertNotError(t, err, "")
+	other, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", runAfter, expiresAt, empty, queued_jobs.EnqueueOptions{ConcurrencyKey: "acct_456", ConcurrencyLimit: 1})
+	test.AssertNotError(t, err, "")
+
+	acquired, err := queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), first.ID.String())
+	// The second acct_123 job is older, but has to wait.
+	acquired, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), other.ID.String())
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	err = services.HandleStatusCallback(first.ID, "echo", models.StatusSucceeded, first.Attempts, true)
+	test.AssertNotError(t, err, "")
+	acquired, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ConcurrencyKey.String, "acct_123")
+}
+
+func TestAcquireConcurrencyLimit(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+
+	expiresAt := types.NullTime{Valid: false}
+	for i := 0; i < 3; i++ {
+		_, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", time.Now().UTC(), expiresAt, empty, queued_jobs.EnqueueOptions{ConcurrencyKey: "acct_123", ConcurrencyLimit: 2})
+		test.AssertNotError(t, err, "")
+	}
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertEquals(t, err, sql.ErrNoRows)
+}
+
+func TestAcquireConcurrencyKeyTwoThreads(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+	expiresAt := types.NullTime{Valid: false}
+	for i := 0; i < 2; i++ {
+		_, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", time.Now().UTC(), expiresAt, empty, queued_jobs.EnqueueOptions{ConcurrencyKey: "acct_123", ConcurrencyLimit: 1})
+		test.AssertNotError(t, err, "")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var err1, err2 error
+	go func() {
+		_, err1 = queued_jobs.Acquire("echo")
+		wg.Done()
+	}()
+	go func() {
+		_, err2 = queued_jobs.Acquire("echo")
+		wg.Done()
+	}()
+	wg.Wait()
+	test.Assert(t, err1 == sql.ErrNoRows || err2 == sql.ErrNoRows, "expected at most one job to be acquired")
+	counts, err := queued_jobs.GetCountsByStatus(models.StatusInProgress)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, counts["echo"] <= 1, "expected at most one job in progress")
+}
+
+func TestAcquireOrderingKeyHeadOfLine(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	_, err := jobs.Create(sampleJob)
+	test.AssertNotError(t, err, "")
+
+	expiresAt := types.NullTime{Valid: false}
+	first, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", time.Now().UTC(), expiresAt, empty, queued_jobs.EnqueueOptions{OrderingKey: "acct_123"})
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, first.OrderingKey.String, "acct_123")
+	second, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", time.Now().UTC(), expiresAt, empty, queued_jobs.EnqueueOptions{OrderingKey: "acct_123"})
+	test.AssertNotError(t, err, "")
+	other, err := queued_jobs.EnqueueWithOptions(factory.RandomId("job_"), "echo", time.Now().UTC(), expiresAt, empty, queued_jobs.EnqueueOptions{OrderingKey: "acct_456"})
+	test.AssertNotError(t, err, "")
+
+	acquired, err := queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), first.ID.String())
+	acquired, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), other.ID.String())
+
+	// A retry pushes the first job's run_after forward, but the second job
+	// still has to wait for it.
+	err = services.HandleStatusCallback(first.ID, "echo", models.StatusFailed, first.Attempts, true)
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	_, err = queued_jobs.Update(first.ID, "echo", types.NullTime{Valid: true, Time: time.Now().UTC()}, expiresAt, nil)
+	test.AssertNotError(t, err, "")
+	acquired, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), first.ID.String())
+	err = services.HandleStatusCallback(first.ID, "echo", models.StatusSucceeded, acquired.Attempts, true)
+	test.AssertNotError(t, err, "")
+
+	acquired, err = queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, acquired.ID.String(), second.ID.String())
+}
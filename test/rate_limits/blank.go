@@ -0,0 +1,3 @@
+package test_rate_limits
+
+// This needs to be here so godep doesn't complain
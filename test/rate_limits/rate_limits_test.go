@@ -0,0 +1,98 @@
+package test_rate_limits
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/jobs"
+	"github.com/Shyp/rickover/models/rate_limits"
+	"github.com/Shyp/rickover/test"
+)
+
+func newJob(t *testing.T) models.Job {
+	t.Helper()
+	id, _ := types.GenerateUUID("jobname_")
+	return models.Job{
+		Name:             id.String(),
+		DeliveryStrategy: models.StrategyAtLeastOnce,
+		Attempts:         3,
+		Concurrency:      1,
+	}
+}
+
+func TestTakeTokenRateLimited(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	j := newJob(t)
+	j.RateLimit = 2
+	j.RateIntervalMs = 1000
+	created, err := jobs.Create(j)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, created.RateBurst, uint32(2))
+
+	// The bucket starts full.
+	for i := 0; i < 2; i++ {
+		ok, _, err := rate_limits.TakeToken(j.Name)
+		test.AssertNotError(t, err, "")
+		test.AssertEquals(t, ok, true)
+	}
+	ok, wait, err := rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, false)
+	test.AssertBetween(t, int64(wait), int64(1), int64(600*time.Millisecond))
+
+	time.Sleep(wait + 20*time.Millisecond)
+	ok, _, err = rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, true)
+}
+
+func TestTakeTokenUnlimited(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	j := newJob(t)
+	_, err := jobs.Create(j)
+	test.AssertNotError(t, err, "")
+	for i := 0; i < 5; i++ {
+		ok, _, err := rate_limits.TakeToken(j.Name)
+		test.AssertNotError(t, err, "")
+		test.AssertEquals(t, ok, true)
+	}
+}
+
+func TestReturnToken(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	j := newJob(t)
+	j.RateLimit = 1
+	j.RateIntervalMs = 60000
+	_, err := jobs.Create(j)
+	test.AssertNotError(t, err, "")
+
+	ok, _, err := rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, true)
+	ok, _, err = rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, false)
+
+	err = rate_limits.ReturnToken(j.Name)
+	test.AssertNotError(t, err, "")
+	ok, _, err = rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, true)
+
+	// The bucket doesn't grow past the burst.
+	err = rate_limits.ReturnToken(j.Name)
+	test.AssertNotError(t, err, "")
+	err = rate_limits.ReturnToken(j.Name)
+	test.AssertNotError(t, err, "")
+	ok, _, err = rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, true)
+	ok, _, err = rate_limits.TakeToken(j.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ok, false)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Shyp/go-types"
+	"github.com/Shyp/rest"
 	"github.com/Shyp/rickover/models"
 	"github.com/Shyp/rickover/models/archived_jobs"
 	"github.com/Shyp/rickover/models/jobs"
@@ -334,3 +335,115 @@ func TestCreateJobAtMostOnceSuccess(t *testing.T) {
 	server.Get(u).ServeHTTP(w, req)
 	test.AssertEquals(t, w.Code, http.StatusCreated)
 }
+
+func TestUpdateQueuedJob(t *testing.T) {
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+
+	runAfter := time.Now().UTC().Add(time.Hour)
+	w := httptest.NewRecorder()
+	ujr := &server.UpdateJobRequest{
+		Data:     json.RawMessage([]byte(`{"foo":"bar"}`)),
+		RunAfter: types.NullTime{Valid: true, Time: runAfter},
+	}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(ujr)
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/v1/jobs/echo/%s", qj.ID.String()), b)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	var j models.QueuedJob
+	err := json.NewDecoder(w.Body).Decode(&j)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, j.ID.String(), qj.ID.String())
+	test.AssertEquals(t, string(j.Data), `{"foo": "bar"}`)
+	test.AssertEquals(t, j.ExpiresAt.Valid, qj.ExpiresAt.Valid)
+	diff := j.RunAfter.Sub(runAfter)
+	test.Assert(t, diff < 20*time.Millisecond, "")
+	test.Assert(t, diff > -20*time.Millisecond, "")
+}
+
+func TestUpdateInProgressJobFails(t *testing.T) {
+	defer test.TearDown(t)
+	qj := factory.CreateQueuedJob(t, factory.EmptyData)
+	_, err := queued_jobs.Acquire(qj.Name)
+	test.AssertNotError(t, err, "")
+
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {"foo": "bar"}}`)
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/v1/jobs/echo/%s", qj.ID.String()), b)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	var e rest.Error
+	err = json.NewDecoder(w.Body).Decode(&e)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, e.ID, "job_in_progress")
+}
+
+func TestUpdateArchivedJobFails(t *testing.T) {
+	defer test.TearDown(t)
+	aj := factory.CreateArchivedJob(t, factory.EmptyData, models.StatusSucceeded)
+	w := httptest.NewRecorder()
+	b := bytes.NewBufferString(`{"data": {"foo": "bar"}}`)
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/v1/jobs/echo/%s", aj.ID.String()), b)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+}
+
+func TestRunNowMovesUpDelayedJob(t *testing.T) {
+	defer test.TearDown(t)
+	_ = factory.CreateJob(t, factory.SampleJob)
+	runAfter := time.Now().UTC().Add(24 * time.Hour)
+	qj, err := queued_jobs.Enqueue(factory.JobId, "echo", runAfter, types.NullTime{Valid: false}, factory.EmptyData)
+	test.AssertNotError(t, err, "")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/v1/jobs/echo/%s/run-now", qj.ID.String()), nil)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	var j models.QueuedJob
+	err = json.NewDecoder(w.Body).Decode(&j)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, j.ID.String(), qj.ID.String())
+	test.Assert(t, time.Since(j.RunAfter) < 100*time.Millisecond, "")
+	test.Assert(t, time.Since(j.RunAfter) > -100*time.Millisecond, "")
+}
+
+func TestRunAllNowMovesUpDelayedJobs(t *testing.T) {
+	defer test.TearDown(t)
+	_ = factory.CreateJob(t, factory.SampleJob)
+	runAfter := time.Now().UTC().Add(24 * time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err := queued_jobs.Enqueue(factory.RandomId("job_"), "echo", runAfter, types.NullTime{Valid: false}, factory.EmptyData)
+		test.AssertNotError(t, err, "")
+	}
+	// Already runnable, so it shouldn't be counted.
+	_, err := queued_jobs.Enqueue(factory.RandomId("job_"), "echo", time.Now().UTC(), types.NullTime{Valid: false}, factory.EmptyData)
+	test.AssertNotError(t, err, "")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/jobs/echo/run-now", nil)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	var resp server.RunNowResponse
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, resp.Count, int64(2))
+
+	_, ready, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ready, 3)
+}
+
+func TestRunAllNowUnknownJobType(t *testing.T) {
+	defer test.TearDown(t)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/jobs/unknown/run-now", nil)
+	req.SetBasicAuth("test", testPassword)
+	server.DefaultServer.ServeHTTP(w, req)
+	test.AssertEquals(t, w.Code, http.StatusNotFound)
+}
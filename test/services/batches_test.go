@@ -0,0 +1,129 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/batches"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+var notifyJob = models.Job{
+	Name:             "export-finished",
+	DeliveryStrategy: models.StrategyAtLeastOnce,
+	Attempts:         3,
+	Concurrency:      1,
+}
+
+func createBatch(t *testing.T) *models.Batch {
+	t.Helper()
+	factory.CreateJob(t, factory.SampleJob)
+	factory.CreateJob(t, notifyJob)
+	b, err := batches.Create(models.Batch{
+		ID:         factory.RandomId(batches.Prefix),
+		OnComplete: types.NullString{Valid: true, String: notifyJob.Name},
+		Data:       json.RawMessage(`{"export": "exp_123"}`),
+	})
+	test.AssertNotError(t, err, "")
+	return b
+}
+
+func enqueueInBatch(t *testing.T, b *models.Batch) *models.QueuedJob {
+	t.Helper()
+	qj, err := services.Enqueue(factory.RandomId("job_"), factory.SampleJob.Name, time.Now(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{BatchID: &b.ID})
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.BatchID.String(), b.ID.String())
+	return qj
+}
+
+func TestBatchCompletesWhenLastJobArchived(t *testing.T) {
+	defer test.TearDown(t)
+	b := createBatch(t)
+	qj1 := enqueueInBatch(t, b)
+	qj2 := enqueueInBatch(t, b)
+	_, err := services.CloseBatch(b.ID)
+	test.AssertNotError(t, err, "")
+
+	err = services.HandleStatusCallback(qj1.ID, qj1.Name, models.StatusSucceeded, qj1.Attempts, true)
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Acquire(notifyJob.Name)
+	test.AssertEquals(t, err, sql.ErrNoRows)
+
+	err = services.HandleStatusCallback(qj2.ID, qj2.Name, models.StatusFailed, qj2.Attempts, false)
+	test.AssertNotError(t, err, "")
+	completed, err := batches.Get(b.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, completed.CompletedAt.Valid, true)
+
+	notify, err := queued_jobs.Acquire(notifyJob.Name)
+	test.AssertNotError(t, err, "")
+	var data services.BatchCompleteData
+	err = json.Unmarshal(notify.Data, &data)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, data.BatchID.String(), b.ID.String())
+	test.AssertEquals(t, string(data.Data), `{"export": "exp_123"}`)
+	test.AssertEquals(t, data.Counts[models.StatusSucceeded], int64(1))
+	test.AssertEquals(t, data.Counts[models.StatusFailed], int64(1))
+}
+
+func TestBatchOpenDoesNotComplete(t *testing.T) {
+	defer test.TearDown(t)
+	b := createBatch(t)
+	qj := enqueueInBatch(t, b)
+	err := services.HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, qj.Attempts, true)
+	test.AssertNotError(t, err, "")
+	open, err := batches.Get(b.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, open.CompletedAt.Valid, false)
+
+	// Closing a batch whose jobs have all finished completes it right away.
+	closed, err := services.CloseBatch(b.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, closed.CompletedAt.Valid, true)
+	_, err = queued_jobs.Acquire(notifyJob.Name)
+	test.AssertNotError(t, err, "")
+
+	counts, err := batches.GetCounts(b.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, counts[models.StatusSucceeded], int64(1))
+}
+
+func TestEnqueueInClosedBatch(t *testing.T) {
+	defer test.TearDown(t)
+	b := createBatch(t)
+	_, err := services.CloseBatch(b.ID)
+	test.AssertNotError(t, err, "")
+	_, err = services.Enqueue(factory.RandomId("job_"), factory.SampleJob.Name, time.Now(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{BatchID: &b.ID})
+	test.AssertEquals(t, err, batches.ErrClosed)
+
+	unknownID := factory.RandomId(batches.Prefix)
+	_, err = services.Enqueue(factory.RandomId("job_"), factory.SampleJob.Name, time.Now(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{BatchID: &unknownID})
+	test.AssertEquals(t, err, batches.ErrNotFound)
+}
+
+func TestEnqueueInBatchWithOtherOptions(t *testing.T) {
+	defer test.TearDown(t)
+	b := createBatch(t)
+	parent := factory.CreateQueuedJob(t, factory.EmptyData)
+	qj, err := services.Enqueue(factory.RandomId("job_"), factory.SampleJob.Name, time.Now(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{
+		DependsOn:        []types.PrefixUUID{parent.ID},
+		BatchID:          &b.ID,
+		ConcurrencyKey:   "acct_123",
+		ConcurrencyLimit: 2,
+		OrderingKey:      "acct_123",
+	})
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.Status, models.StatusBlocked)
+	test.AssertEquals(t, qj.BatchID.String(), b.ID.String())
+	test.AssertEquals(t, len(qj.DependsOn), 1)
+	test.AssertEquals(t, qj.ConcurrencyKey.String, "acct_123")
+	test.AssertEquals(t, qj.ConcurrencyLimit, uint8(2))
+	test.AssertEquals(t, qj.OrderingKey.String, "acct_123")
+}
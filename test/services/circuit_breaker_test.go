@@ -0,0 +1,153 @@
+package services
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/circuit_breakers"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func newFailingServer(requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"title": "Internal server error", "id": "server_error"}`))
+	}))
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	var requests int32
+	s := newFailingServer(&requests)
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	jp.Breaker = &services.CircuitBreaker{Threshold: 2, Cooldown: time.Hour}
+
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	for i := 0; i < 2; i++ {
+		factory.CreateQueuedJobOnly(t, job.Name, factory.EmptyData)
+	}
+	for i := 0; i < 2; i++ {
+		qj, err := queued_jobs.Acquire(job.Name)
+		test.AssertNotError(t, err, "")
+		jp.DoWork(qj)
+	}
+	test.AssertEquals(t, atomic.LoadInt32(&requests), int32(2))
+
+	cb, err := circuit_breakers.Get(job.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, cb.State, models.CircuitOpen)
+	test.AssertEquals(t, cb.Failures, uint32(2))
+	test.AssertEquals(t, cb.OpenUntil.Valid, true)
+
+	// The last job stays in the queue while the circuit is open.
+	_, err = queued_jobs.Acquire(job.Name)
+	test.AssertEquals(t, err, sql.ErrNoRows)
+}
+
+func TestOpenCircuitReleasesJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	var requests int32
+	s := newFailingServer(&requests)
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	jp.Breaker = &services.CircuitBreaker{Threshold: 1, Cooldown: time.Hour}
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	// Another dequeuer opens the circuit before this one sends the job.
+	_, err = circuit_breakers.RecordFailure(job.Name, 1, time.Hour)
+	test.AssertNotError(t, err, "")
+
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, atomic.LoadInt32(&requests), int32(0))
+	released, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, released.Status, models.StatusQueued)
+	test.AssertEquals(t, released.Attempts, qj.Attempts)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	cb := &services.CircuitBreaker{Threshold: 1, Cooldown: 30 * time.Millisecond}
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err := circuit_breakers.RecordFailure(job.Name, cb.Threshold, cb.Cooldown)
+	test.AssertNotError(t, err, "")
+
+	ok, _ := cb.Allow(job.Name)
+	test.AssertEquals(t, ok, false)
+
+	time.Sleep(40 * time.Millisecond)
+	ok, probe := cb.Allow(job.Name)
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, probe, true)
+	// Only one dequeuer gets to send a probe.
+	ok, _ = cb.Allow(job.Name)
+	test.AssertEquals(t, ok, false)
+
+	cb.Record(job.Name, nil, true)
+	c, err := circuit_breakers.Get(job.Name)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, c.State, models.CircuitClosed)
+	test.AssertEquals(t, c.Failures, uint32(0))
+	test.AssertEquals(t, c.OpenUntil.Valid, false)
+}
+
+func TestFailedProbeReopensCircuit(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err := circuit_breakers.RecordFailure(job.Name, 5, time.Hour)
+	test.AssertNotError(t, err, "")
+	c, err := circuit_breakers.HalfOpen(job.Name, time.Hour)
+	test.AssertEquals(t, err, circuit_breakers.ErrNoProbe)
+
+	// Force the circuit open, then let it cool down.
+	for i := 0; i < 4; i++ {
+		_, err = circuit_breakers.RecordFailure(job.Name, 5, 10*time.Millisecond)
+		test.AssertNotError(t, err, "")
+	}
+	time.Sleep(20 * time.Millisecond)
+	c, err = circuit_breakers.HalfOpen(job.Name, time.Hour)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, c.State, models.CircuitHalfOpen)
+
+	c, err = circuit_breakers.RecordFailure(job.Name, 5, time.Hour)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, c.State, models.CircuitOpen)
+	test.AssertEquals(t, c.Failures, uint32(6))
+}
+
+func TestCircuitBreakerCachesClosedCircuit(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	cb := &services.CircuitBreaker{Threshold: 1, Cooldown: time.Hour, CacheTTL: time.Hour}
+	job, _ := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	ok, _ := cb.Allow(job.Name)
+	test.AssertEquals(t, ok, true)
+
+	// Another process opens the circuit; this one doesn't notice until its
+	// cache expires.
+	_, err := circuit_breakers.RecordFailure(job.Name, 1, time.Hour)
+	test.AssertNotError(t, err, "")
+	ok, _ = cb.Allow(job.Name)
+	test.AssertEquals(t, ok, true)
+
+	fresh := &services.CircuitBreaker{Threshold: 1, Cooldown: time.Hour}
+	ok, _ = fresh.Allow(job.Name)
+	test.AssertEquals(t, ok, false)
+}
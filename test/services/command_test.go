@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func sh(script string) services.Command {
+	return services.Command{Path: "/bin/sh", Args: []string{"-c", script}, Timeout: 5 * time.Second}
+}
+
+func TestCommandWorkerSucceeds(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	w := services.NewCommandWorker()
+	w.Handle(job.Name, sh("exit 0"))
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = w.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusSucceeded)
+}
+
+func TestCommandWorkerNotRetryable(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	w := services.NewCommandWorker()
+	w.Handle(job.Name, sh("echo invalid address >&2; exit 65"))
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = w.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
+
+func TestCommandWorkerFailsJobWithoutCommand(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	w := services.NewCommandWorker()
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = w.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
+
+func TestCommandWorkerRetriesFailure(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	w := services.NewCommandWorker()
+	w.Handle(job.Name, sh("exit 1"))
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = w.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+}
+
+func TestCommandWorkerReleasesCancelledJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	w := services.NewCommandWorker()
+	w.Handle(job.Name, sh("sleep 10"))
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = w.DoWorkContext(ctx, acquired)
+	test.AssertEquals(t, err, context.DeadlineExceeded)
+	released, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, released.Status, models.StatusQueued)
+	test.AssertEquals(t, released.Attempts, qj.Attempts)
+}
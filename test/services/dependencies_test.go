@@ -0,0 +1,117 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func enqueueChild(t *testing.T, parents ...types.PrefixUUID) *models.QueuedJob {
+	t.Helper()
+	qj, err := services.Enqueue(factory.RandomId("job_"), "echo", time.Now().UTC(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{DependsOn: parents})
+	test.AssertNotError(t, err, "")
+	return qj
+}
+
+func TestDependentJobUnblockedWhenParentsSucceed(t *testing.T) {
+	defer test.TearDown(t)
+	parent1 := factory.CreateQueuedJob(t, factory.EmptyData)
+	parent2 := factory.CreateQueuedJobOnly(t, "echo", factory.EmptyData)
+	child := enqueueChild(t, parent1.ID, parent2.ID)
+	test.AssertEquals(t, child.Status, models.StatusBlocked)
+	test.AssertEquals(t, len(child.DependsOn), 2)
+	test.AssertEquals(t, child.DependsOn[0].String(), parent1.ID.String())
+
+	err := services.HandleStatusCallback(parent1.ID, "echo", models.StatusSucceeded, parent1.Attempts, true)
+	test.AssertNotError(t, err, "")
+	qj, err := queued_jobs.Get(child.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.Status, models.StatusBlocked)
+
+	err = services.HandleStatusCallback(parent2.ID, "echo", models.StatusSucceeded, parent2.Attempts, true)
+	test.AssertNotError(t, err, "")
+	qj, err = queued_jobs.Get(child.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.Status, models.StatusQueued)
+}
+
+func TestDependentJobsFailWhenParentFails(t *testing.T) {
+	defer test.TearDown(t)
+	parent := factory.CreateQueuedJob(t, factory.EmptyData)
+	child := enqueueChild(t, parent.ID)
+	grandchild := enqueueChild(t, child.ID)
+
+	err := services.HandleStatusCallback(parent.ID, "echo", models.StatusFailed, parent.Attempts, false)
+	test.AssertNotError(t, err, "")
+	for _, id := range []types.PrefixUUID{child.ID, grandchild.ID} {
+		_, err = queued_jobs.Get(id)
+		test.AssertEquals(t, err, queued_jobs.ErrNotFound)
+		aj, err := archived_jobs.Get(id)
+		test.AssertNotError(t, err, "")
+		test.AssertEquals(t, aj.Status, models.StatusFailed)
+	}
+}
+
+func TestDependentJobQueuedIfParentAlreadySucceeded(t *testing.T) {
+	defer test.TearDown(t)
+	parent := factory.CreateArchivedJob(t, factory.EmptyData, models.StatusSucceeded)
+	child := enqueueChild(t, parent.ID)
+	test.AssertEquals(t, child.Status, models.StatusQueued)
+}
+
+func TestDependentJobRejectedIfParentAlreadyFailed(t *testing.T) {
+	defer test.TearDown(t)
+	parent := factory.CreateArchivedJob(t, factory.EmptyData, models.StatusFailed)
+	_, err := services.Enqueue(factory.RandomId("job_"), "echo", time.Now().UTC(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{DependsOn: []types.PrefixUUID{parent.ID}})
+	_, ok := err.(*services.FailedParentError)
+	test.Assert(t, ok, "expected a FailedParentError")
+}
+
+func TestDependentJobRejectedIfParentUnknown(t *testing.T) {
+	defer test.TearDown(t)
+	factory.CreateJob(t, factory.SampleJob)
+	_, err := services.Enqueue(factory.RandomId("job_"), "echo", time.Now().UTC(), types.NullTime{Valid: false}, factory.EmptyData, queued_jobs.EnqueueOptions{DependsOn: []types.PrefixUUID{factory.RandomId("job_")}})
+	_, ok := err.(*services.UnknownParentError)
+	test.Assert(t, ok, "expected an UnknownParentError")
+}
+
+func TestResolveBlockedJobsUnblocksOrphanedJob(t *testing.T) {
+	defer test.TearDown(t)
+	parent := factory.CreateQueuedJob(t, factory.EmptyData)
+	child := enqueueChild(t, parent.ID)
+	// Archive the parent without resolving its dependents, as if the process
+	// died right after archiving it.
+	_, err := archived_jobs.Create(parent.ID, "echo", models.StatusSucceeded, parent.Attempts)
+	test.AssertNotError(t, err, "")
+	err = queued_jobs.DeleteRetry(parent.ID, 3)
+	test.AssertNotError(t, err, "")
+
+	count, err := services.ResolveBlockedJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 1)
+	qj, err := queued_jobs.Get(child.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.Status, models.StatusQueued)
+
+	count, err = services.ResolveBlockedJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 0)
+}
+
+func TestBlockedJobNotAcquired(t *testing.T) {
+	defer test.TearDown(t)
+	parent := factory.CreateQueuedJob(t, factory.EmptyData)
+	_, err := queued_jobs.Acquire("echo")
+	test.AssertNotError(t, err, "")
+	enqueueChild(t, parent.ID)
+	_, err = queued_jobs.Acquire("echo")
+	test.AssertEquals(t, err, sql.ErrNoRows)
+}
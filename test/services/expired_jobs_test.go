@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func enqueueExpiring(t *testing.T, name string, expiresAt time.Time) *models.QueuedJob {
+	t.Helper()
+	expires := types.NullTime{Time: expiresAt, Valid: true}
+	qj, err := queued_jobs.Enqueue(factory.RandomId("job_"), name, time.Now().UTC(), expires, factory.EmptyData)
+	test.AssertNotError(t, err, "")
+	return qj
+}
+
+func TestArchiveExpiredJobs(t *testing.T) {
+	defer test.TearDown(t)
+	job := factory.CreateJob(t, factory.SampleJob)
+	_, err := jobs.Pause(job.Name, types.NullTime{})
+	test.AssertNotError(t, err, "")
+	expired := enqueueExpiring(t, job.Name, time.Now().Add(-1*time.Minute))
+	live := enqueueExpiring(t, job.Name, time.Now().Add(time.Hour))
+
+	count, err := services.ArchiveExpiredJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 1)
+	aj, err := archived_jobs.Get(expired.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusExpired)
+	_, err = queued_jobs.Get(expired.ID)
+	test.AssertEquals(t, err, queued_jobs.ErrNotFound)
+	qj, err := queued_jobs.Get(live.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.Status, models.StatusQueued)
+
+	count, err = services.ArchiveExpiredJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 0)
+}
+
+func TestArchiveExpiredJobsArchivesReleasedJob(t *testing.T) {
+	defer test.TearDown(t)
+	job := factory.CreateJob(t, factory.SampleJob)
+	expired := enqueueExpiring(t, job.Name, time.Now().Add(-1*time.Minute))
+	// A sweep that couldn't archive the job puts it back in the queue.
+	acquired, err := queued_jobs.AcquireExpired()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(acquired), 1)
+	err = queued_jobs.Release(expired.ID)
+	test.AssertNotError(t, err, "")
+
+	count, err := services.ArchiveExpiredJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 1)
+	aj, err := archived_jobs.Get(expired.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusExpired)
+}
+
+func TestExpiredJobEnqueuesOnExpireJob(t *testing.T) {
+	defer test.TearDown(t)
+	createChargeJobs(t)
+	_, err := jobs.Create(models.Job{
+		Name:             "notify-expired",
+		DeliveryStrategy: models.StrategyAtLeastOnce,
+		Attempts:         3,
+		Concurrency:      1,
+	})
+	test.AssertNotError(t, err, "")
+	_, err = jobs.Create(models.Job{
+		Name:             "charge-card-expiring",
+		DeliveryStrategy: models.StrategyAtMostOnce,
+		Attempts:         1,
+		Concurrency:      1,
+		OnFailure:        types.NullString{Valid: true, String: "refund"},
+		OnExpire:         types.NullString{Valid: true, String: "notify-expired"},
+	})
+	test.AssertNotError(t, err, "")
+	parent := enqueueExpiring(t, "charge-card-expiring", time.Now().Add(-1*time.Minute))
+
+	count, err := services.ArchiveExpiredJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 1)
+	qj, err := queued_jobs.Acquire("notify-expired")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.ParentID.String(), parent.ID.String())
+	var data services.FollowUpData
+	err = json.Unmarshal(qj.Data, &data)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, data.Status, models.StatusExpired)
+	_, err = queued_jobs.Acquire("refund")
+	test.AssertError(t, err, "")
+}
+
+func TestExpiredJobDoesNotEnqueueOnFailureJob(t *testing.T) {
+	defer test.TearDown(t)
+	createChargeJobs(t)
+	enqueueExpiring(t, "charge-card", time.Now().Add(-1*time.Minute))
+
+	count, err := services.ArchiveExpiredJobs()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, count, 1)
+	_, err = queued_jobs.Acquire("refund")
+	test.AssertError(t, err, "")
+}
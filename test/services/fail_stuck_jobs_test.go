@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/workers"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func TestArchiveStuckJobsFailsDeadWorkerJobs(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	id, err := types.GenerateUUID(workers.Prefix)
+	test.AssertNotError(t, err, "")
+	w := &models.Worker{ID: id, Hostname: "dequeuer-1", Pid: 4123, Version: "1.1"}
+	_, err = workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err = queued_jobs.AcquireWorker(job.Name, &w.ID)
+	test.AssertNotError(t, err, "")
+
+	// The worker is alive, so the job is left alone.
+	err = services.ArchiveStuckJobs(7 * time.Minute)
+	test.AssertNotError(t, err, "")
+	inProgress, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, inProgress.Status, models.StatusInProgress)
+
+	_, err = db.Conn.Exec("UPDATE workers SET heartbeat_at = now() - interval '1 hour' WHERE id = $1", w.ID)
+	test.AssertNotError(t, err, "")
+	err = services.ArchiveStuckJobs(7 * time.Minute)
+	test.AssertNotError(t, err, "")
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+	test.Assert(t, retried.WorkerID == nil, "retried job should not have a worker")
+	all, err := workers.GetAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(all), 0)
+}
+
+func TestArchiveStuckJobsLeavesAcceptedJobs(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	id, err := types.GenerateUUID(workers.Prefix)
+	test.AssertNotError(t, err, "")
+	w := &models.Worker{ID: id, Hostname: "dequeuer-1", Pid: 4123, Version: "1.1"}
+	_, err = workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	_, err = queued_jobs.AcquireWorker(job.Name, &w.ID)
+	test.AssertNotError(t, err, "")
+	err = queued_jobs.Accepted(qj.ID)
+	test.AssertNotError(t, err, "")
+
+	_, err = db.Conn.Exec("UPDATE workers SET heartbeat_at = now() - interval '1 hour' WHERE id = $1", w.ID)
+	test.AssertNotError(t, err, "")
+	err = services.ArchiveStuckJobs(7 * time.Minute)
+	test.AssertNotError(t, err, "")
+	inProgress, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, inProgress.Status, models.StatusInProgress)
+	test.Assert(t, inProgress.WorkerID == nil, "accepted job should not have a worker")
+}
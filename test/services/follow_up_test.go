@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func createChargeJobs(t *testing.T) {
+	t.Helper()
+	test.SetUp(t)
+	for _, name := range []string{"send-receipt", "refund"} {
+		_, err := jobs.Create(models.Job{
+			Name:             name,
+			DeliveryStrategy: models.StrategyAtLeastOnce,
+			Attempts:         3,
+			Concurrency:      1,
+		})
+		test.AssertNotError(t, err, "")
+	}
+	_, err := jobs.Create(models.Job{
+		Name:             "charge-card",
+		DeliveryStrategy: models.StrategyAtMostOnce,
+		Attempts:         1,
+		Concurrency:      1,
+		OnSuccess:        types.NullString{Valid: true, String: "send-receipt"},
+		OnFailure:        types.NullString{Valid: true, String: "refund"},
+	})
+	test.AssertNotError(t, err, "")
+}
+
+func TestSuccessEnqueuesOnSuccessJob(t *testing.T) {
+	defer test.TearDown(t)
+	createChargeJobs(t)
+	parent := factory.CreateQueuedJobOnly(t, "charge-card", json.RawMessage(`{"card": "card_123"}`))
+
+	result := json.RawMessage(`{"charge": "ch_123"}`)
+	err := services.HandleStatusCallbackResult(parent.ID, "charge-card", models.StatusSucceeded, 1, true, result)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(parent.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusSucceeded)
+
+	qj, err := queued_jobs.Acquire("send-receipt")
+	test.AssertNotError(t, err, "")
+	test.AssertNotNil(t, qj.ParentID, "")
+	test.AssertEquals(t, qj.ParentID.String(), parent.ID.String())
+	var data services.FollowUpData
+	err = json.Unmarshal(qj.Data, &data)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, data.ParentID.String(), parent.ID.String())
+	test.AssertEquals(t, data.ParentName, "charge-card")
+	test.AssertEquals(t, data.Status, models.StatusSucceeded)
+	test.AssertEquals(t, string(data.Data), `{"card": "card_123"}`)
+	test.AssertEquals(t, string(data.Result), `{"charge": "ch_123"}`)
+
+	_, err = queued_jobs.Acquire("refund")
+	test.AssertError(t, err, "")
+}
+
+func TestFailureEnqueuesOnFailureJob(t *testing.T) {
+	defer test.TearDown(t)
+	createChargeJobs(t)
+	parent := factory.CreateQueuedJobOnly(t, "charge-card", factory.EmptyData)
+
+	err := services.HandleStatusCallback(parent.ID, "charge-card", models.StatusFailed, 1, true)
+	test.AssertNotError(t, err, "")
+	qj, err := queued_jobs.Acquire("refund")
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, qj.ParentID.String(), parent.ID.String())
+	var data services.FollowUpData
+	err = json.Unmarshal(qj.Data, &data)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, data.Status, models.StatusFailed)
+	test.AssertEquals(t, string(data.Result), "null")
+}
+
+func TestDuplicateCallbackEnqueuesOneFollowUp(t *testing.T) {
+	defer test.TearDown(t)
+	createChargeJobs(t)
+	parent := factory.CreateQueuedJobOnly(t, "charge-card", factory.EmptyData)
+
+	err := services.HandleStatusCallback(parent.ID, "charge-card", models.StatusSucceeded, 1, true)
+	test.AssertNotError(t, err, "")
+	err = services.HandleStatusCallback(parent.ID, "charge-card", models.StatusSucceeded, 1, true)
+	test.AssertEquals(t, err, queued_jobs.ErrNotFound)
+
+	counts, err := queued_jobs.GetCountsByStatus(models.StatusQueued)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, counts["send-receipt"], int64(1))
+}
+
+func TestFollowUpChainStopsAtMaxDepth(t *testing.T) {
+	defer test.TearDown(t)
+	test.SetUp(t)
+	for _, name := range []string{"ping", "pong"} {
+		_, err := jobs.Create(models.Job{
+			Name:             name,
+			DeliveryStrategy: models.StrategyAtLeastOnce,
+			Attempts:         3,
+			Concurrency:      1,
+		})
+		test.AssertNotError(t, err, "")
+	}
+	// Job types can't be made to follow up with each other through the API,
+	// but the chain should still end if they do.
+	_, err := db.Conn.Exec("UPDATE jobs SET on_success = CASE name WHEN 'ping' THEN 'pong' ELSE 'ping' END")
+	test.AssertNotError(t, err, "")
+	defer func(depth int) { services.MaxFollowUpDepth = depth }(services.MaxFollowUpDepth)
+	services.MaxFollowUpDepth = 2
+
+	qj := factory.CreateQueuedJobOnly(t, "ping", factory.EmptyData)
+	for _, next := range []string{"pong", "ping"} {
+		err := services.HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, 3, true)
+		test.AssertNotError(t, err, "")
+		qj, err = queued_jobs.Acquire(next)
+		test.AssertNotError(t, err, "")
+	}
+	err = services.HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, 3, true)
+	test.AssertNotError(t, err, "")
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 0)
+}
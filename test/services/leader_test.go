@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+)
+
+func newTestLeader(started chan<- *services.Leader) *services.Leader {
+	l := services.NewLeader()
+	l.LockID = 5432
+	l.Interval = 10 * time.Millisecond
+	l.Go(func(ctx context.Context) {
+		started <- l
+		<-ctx.Done()
+	})
+	return l
+}
+
+func TestLeaderFailsOver(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	started := make(chan *services.Leader, 2)
+	first := newTestLeader(started)
+	second := newTestLeader(started)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go first.Run(ctx1)
+	go second.Run(ctx2)
+
+	var leader *services.Leader
+	select {
+	case leader = <-started:
+	case <-time.After(time.Second):
+		t.Fatal("no leader was elected")
+	}
+	follower, cancelLeader := second, cancel1
+	if leader == second {
+		follower, cancelLeader = first, cancel2
+	}
+	test.Assert(t, leader.IsLeader(), "expected leader to be the leader")
+	// Give the follower a few chances to take the lock.
+	time.Sleep(50 * time.Millisecond)
+	test.Assert(t, !follower.IsLeader(), "expected only one leader")
+	test.AssertEquals(t, len(started), 0)
+
+	cancelLeader()
+	select {
+	case l := <-started:
+		test.AssertEquals(t, l, follower)
+	case <-time.After(time.Second):
+		t.Fatal("follower didn't take over")
+	}
+	test.Assert(t, !leader.IsLeader(), "expected old leader to step down")
+}
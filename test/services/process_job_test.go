@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -124,6 +125,52 @@ func TestWorkerRetriesJSON503(t *testing.T) {
 	test.AssertNotError(t, err, "")
 }
 
+func TestWorkerFailsJobAfterRepeated503s(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	originalSleepWorker503Factor := services.UnavailableSleepFactor
+	services.UnavailableSleepFactor = 0
+	defer func() {
+		services.UnavailableSleepFactor = originalSleepWorker503Factor
+	}()
+
+	var mu sync.Mutex
+	count := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("{}"))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	// If the job were treated as accepted, DoWork would wait this long for a
+	// callback.
+	jp.Timeout = time.Hour
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	done := make(chan error, 1)
+	go func() {
+		done <- jp.DoWork(acquired)
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("DoWork waited for a callback after repeated 503s")
+	}
+	test.AssertNotError(t, err, "")
+	mu.Lock()
+	test.AssertEquals(t, count, 3)
+	mu.Unlock()
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+}
+
 func TestWorkerWaitsConnectTimeout(t *testing.T) {
 	test.SetUp(t)
 	defer test.TearDown(t)
@@ -197,3 +244,241 @@ func TestWorkerDoesNotWaitConnectionFailure(t *testing.T) {
 	test.AssertNotError(t, err, "")
 	test.AssertEquals(t, aj.Status, models.StatusFailed)
 }
+
+func TestWorkerRequeuesRetryAfter(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"title": "Too many requests", "id": "too_many_requests"}`))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+
+	requeued, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, requeued.Status, models.StatusQueued)
+	test.AssertEquals(t, requeued.Attempts, qj.Attempts)
+	wait := requeued.RunAfter.Sub(time.Now())
+	test.AssertBetween(t, int64(wait), int64(25*time.Second), int64(30*time.Second))
+}
+
+func TestWorkerFailsClientErrorWithoutRetrying(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"title": "Invalid invoice", "id": "invalid_invoice"}`))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
+
+func TestWorkerRetriesServerError(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<html>Internal Server Error</html>"))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+}
+
+func createSynchronousJob(t *testing.T) (*models.Job, *models.QueuedJob) {
+	t.Helper()
+	id, _ := types.GenerateUUID("jobname_")
+	job := factory.CreateJob(t, models.Job{
+		Name:             id.String(),
+		DeliveryStrategy: models.StrategyAtLeastOnce,
+		Attempts:         3,
+		Concurrency:      1,
+		Synchronous:      true,
+	})
+	qj := factory.CreateQueuedJobOnly(t, job.Name, factory.EmptyData)
+	return &job, qj
+}
+
+func TestSynchronousJobArchivedFromResponse(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status": "succeeded"}`))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	// Make sure we don't wait for a callback.
+	jp.Timeout = time.Hour
+
+	job, qj := createSynchronousJob(t)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusSucceeded)
+}
+
+func TestSynchronousJobFailedNotRetryable(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status": "failed", "retryable": false}`))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	jp.Timeout = time.Hour
+
+	job, qj := createSynchronousJob(t)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
+
+func TestAsynchronousJobIgnoresResponseStatus(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status": "failed", "retryable": false}`))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	go func() {
+		err := services.HandleStatusCallback(qj.ID, qj.Name, models.StatusSucceeded, qj.Attempts, true)
+		test.AssertNotError(t, err, "")
+	}()
+	err = jp.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusSucceeded)
+}
+
+func TestDoWorkContextStopsWaiting(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("{}"))
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+	jp.Timeout = time.Hour
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = jp.DoWorkContext(ctx, acquired)
+	test.AssertEquals(t, err, context.DeadlineExceeded)
+
+	// The job is still in progress; nothing was recorded for it.
+	inProgress, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, inProgress.Status, models.StatusInProgress)
+	test.AssertEquals(t, inProgress.Attempts, qj.Attempts)
+}
+
+func TestDoWorkContextReleasesUnacceptedJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never respond; the request is cancelled by the worker.
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+	jp := factory.Processor(s.URL)
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = jp.DoWorkContext(ctx, acquired)
+	test.AssertEquals(t, err, context.DeadlineExceeded)
+
+	released, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, released.Status, models.StatusQueued)
+	test.AssertEquals(t, released.Attempts, qj.Attempts)
+}
+
+// cancelAfterResponse cancels the request's context as soon as the downstream
+// server has responded.
+type cancelAfterResponse struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfterResponse) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	c.cancel()
+	return res, err
+}
+
+func TestDoWorkContextKeepsJobAcceptedAsContextIsCancelled(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("{}"))
+	}))
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jp := factory.Processor(s.URL)
+	jp.Timeout = time.Hour
+	jp.Client.Client.Client = &http.Client{Transport: &cancelAfterResponse{cancel: cancel}}
+
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = jp.DoWorkContext(ctx, acquired)
+	test.AssertEquals(t, err, context.Canceled)
+
+	// The downstream server accepted the job, so it isn't released.
+	inProgress, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, inProgress.Status, models.StatusInProgress)
+	test.AssertEquals(t, inProgress.Attempts, qj.Attempts)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/archived_jobs"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func TestRegistrySucceeds(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	r := services.NewRegistry()
+	var ran *models.QueuedJob
+	r.Handle(job.Name, func(ctx context.Context, qj *models.QueuedJob) error {
+		ran = qj
+		return nil
+	})
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = r.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, ran.ID.String(), qj.ID.String())
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusSucceeded)
+}
+
+func TestRegistryPermanentFailure(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	r := services.NewRegistry()
+	r.Handle(job.Name, func(ctx context.Context, qj *models.QueuedJob) error {
+		return services.Permanent(errors.New("invalid address"))
+	})
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = r.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
+
+func TestRegistryRetriesPanic(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	r := services.NewRegistry()
+	r.Handle(job.Name, func(ctx context.Context, qj *models.QueuedJob) error {
+		panic("oh no")
+	})
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = r.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	retried, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, retried.Status, models.StatusQueued)
+	test.AssertEquals(t, retried.Attempts, qj.Attempts-1)
+}
+
+func TestRegistryReleasesCancelledJob(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	r := services.NewRegistry()
+	r.Handle(job.Name, func(ctx context.Context, qj *models.QueuedJob) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = r.DoWorkContext(ctx, acquired)
+	test.AssertEquals(t, err, context.DeadlineExceeded)
+	released, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, released.Status, models.StatusQueued)
+	test.AssertEquals(t, released.Attempts, qj.Attempts)
+}
+
+func TestRegistryFailsJobWithoutHandler(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	job, qj := factory.CreateUniqueQueuedJob(t, factory.EmptyData)
+	r := services.NewRegistry()
+	acquired, err := queued_jobs.Acquire(job.Name)
+	test.AssertNotError(t, err, "")
+	err = r.DoWork(acquired)
+	test.AssertNotError(t, err, "")
+	aj, err := archived_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, aj.Status, models.StatusFailed)
+}
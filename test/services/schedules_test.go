@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/schedules"
+	"github.com/Shyp/rickover/services"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func createSchedule(t *testing.T, nextRunAt time.Time, maxCatchUp int) *models.Schedule {
+	t.Helper()
+	s, err := schedules.Create(models.Schedule{
+		Name:         "every-minute",
+		JobName:      factory.SampleJob.Name,
+		Cron:         "* * * * *",
+		TimeZone:     "UTC",
+		DataTemplate: `{"scheduled_at": "{{.ScheduledAt.Format "15:04"}}"}`,
+		NextRunAt:    nextRunAt,
+		MaxCatchUp:   maxCatchUp,
+	})
+	test.AssertNotError(t, err, "")
+	return s
+}
+
+func TestProcessScheduleEnqueuesMissedTicks(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	factory.CreateJob(t, factory.SampleJob)
+	now := time.Now().UTC()
+	first := now.Truncate(time.Minute).Add(-2 * time.Minute)
+	s := createSchedule(t, first, 3)
+
+	err := services.ProcessSchedule(s, now)
+	test.AssertNotError(t, err, "")
+
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 3)
+
+	id, err := services.ScheduledJobID(s.Name, first)
+	test.AssertNotError(t, err, "")
+	qj, err := queued_jobs.Get(id)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, string(qj.Data), `{"scheduled_at": "`+first.Format("15:04")+`"}`)
+
+	s, err = schedules.Get(s.Name)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, s.NextRunAt.After(now), "expected next_run_at to be in the future")
+}
+
+func TestProcessScheduleTwiceDoesNotDoubleEnqueue(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	factory.CreateJob(t, factory.SampleJob)
+	now := time.Now().UTC()
+	s := createSchedule(t, now.Truncate(time.Minute), 0)
+
+	// Simulate two processes that both read the schedule before either
+	// advanced it.
+	err := services.ProcessSchedule(s, now)
+	test.AssertNotError(t, err, "")
+	err = services.ProcessSchedule(s, now)
+	test.AssertNotError(t, err, "")
+
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 1)
+}
+
+func TestProcessScheduleSkipsOldMissedTicks(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	factory.CreateJob(t, factory.SampleJob)
+	now := time.Now().UTC()
+	last := now.Truncate(time.Minute)
+	s := createSchedule(t, last.Add(-1*time.Hour), 0)
+	test.AssertEquals(t, s.MaxCatchUp, 1)
+
+	err := services.ProcessSchedule(s, now)
+	test.AssertNotError(t, err, "")
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 1)
+	id, err := services.ScheduledJobID(s.Name, last)
+	test.AssertNotError(t, err, "")
+	_, err = queued_jobs.Get(id)
+	test.AssertNotError(t, err, "")
+}
+
+func TestProcessScheduleSkipsTickThatFailsToRender(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	factory.CreateJob(t, factory.SampleJob)
+	now := time.Now().UTC()
+	s := createSchedule(t, now.Truncate(time.Minute), 0)
+	s.DataTemplate = `{"date": {{.ScheduledAt}}}`
+
+	err := services.ProcessSchedule(s, now)
+	test.AssertNotError(t, err, "")
+	allCount, _, err := queued_jobs.CountReadyAndAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, allCount, 0)
+	s, err = schedules.Get(s.Name)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, s.NextRunAt.After(now), "expected next_run_at to be in the future")
+	test.AssertEquals(t, s.LastError.Valid, true)
+}
@@ -30,10 +30,15 @@ func TruncateTables(t testing.TB) error {
 	} else {
 		name = t.Name()
 	}
-	_, err := db.Conn.Exec(fmt.Sprintf("-- %s\n%s;\n%s;\n%s",
+	_, err := db.Conn.Exec(fmt.Sprintf("-- %s\n%s;\n%s;\n%s;\n%s;\n%s;\n%s;\n%s;\n%s",
 		name,
 		getTableDelete("archived_jobs"),
 		getTableDelete("queued_jobs"),
+		getTableDelete("workers"),
+		getTableDelete("batches"),
+		getTableDelete("schedules"),
+		getTableDelete("circuit_breakers"),
+		getTableDelete("rate_limits"),
 		getTableDelete("jobs"),
 	))
 	return err
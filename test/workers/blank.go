@@ -0,0 +1,3 @@
+package test_workers
+
+// This needs to be here so godep doesn't complain
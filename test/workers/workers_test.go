@@ -0,0 +1,97 @@
+package test_workers
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/Shyp/go-types"
+	"github.com/Shyp/rickover/models"
+	"github.com/Shyp/rickover/models/db"
+	"github.com/Shyp/rickover/models/queued_jobs"
+	"github.com/Shyp/rickover/models/workers"
+	"github.com/Shyp/rickover/test"
+	"github.com/Shyp/rickover/test/factory"
+)
+
+func newWorker(t *testing.T) *models.Worker {
+	id, err := types.GenerateUUID(workers.Prefix)
+	test.AssertNotError(t, err, "")
+	return &models.Worker{
+		ID:       id,
+		Hostname: "dequeuer-1",
+		Pid:      4123,
+		Version:  "1.1",
+		JobTypes: []string{"echo"},
+	}
+}
+
+func TestHeartbeatRegisters(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	w := newWorker(t)
+	created, err := workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, created.ID.String(), w.ID.String())
+	test.AssertEquals(t, created.Hostname, "dequeuer-1")
+	test.AssertEquals(t, created.Pid, 4123)
+	test.AssertDeepEquals(t, created.JobTypes, []string{"echo"})
+
+	w.JobTypes = []string{"echo", "render-pdf"}
+	w.Concurrency = 3
+	updated, err := workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	test.AssertDeepEquals(t, updated.JobTypes, []string{"echo", "render-pdf"})
+	test.AssertEquals(t, updated.Concurrency, 3)
+	test.Assert(t, !updated.HeartbeatAt.Before(created.HeartbeatAt), "heartbeat_at should move forward")
+
+	all, err := workers.GetAll()
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, len(all), 1)
+
+	err = workers.Delete(w.ID)
+	test.AssertNotError(t, err, "")
+	err = workers.Delete(w.ID)
+	test.AssertEquals(t, err, workers.ErrNotFound)
+}
+
+func TestAcquireWorkerRecordsWorker(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	w := newWorker(t)
+	_, err := workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	qj := factory.CreateQJ(t)
+	acquired, err := queued_jobs.AcquireWorker(qj.Name, &w.ID)
+	test.AssertNotError(t, err, "")
+	test.AssertNotNil(t, acquired.WorkerID, "")
+	test.AssertEquals(t, acquired.WorkerID.String(), w.ID.String())
+
+	err = queued_jobs.Release(qj.ID)
+	test.AssertNotError(t, err, "")
+	released, err := queued_jobs.Get(qj.ID)
+	test.AssertNotError(t, err, "")
+	test.Assert(t, released.WorkerID == nil, "released job should not have a worker")
+}
+
+func TestDeleteDeadKeepsWorkersHoldingJobs(t *testing.T) {
+	test.SetUp(t)
+	defer test.TearDown(t)
+	w := newWorker(t)
+	_, err := workers.Heartbeat(w)
+	test.AssertNotError(t, err, "")
+	qj := factory.CreateQJ(t)
+	_, err = queued_jobs.AcquireWorker(qj.Name, &w.ID)
+	test.AssertNotError(t, err, "")
+	_, err = db.Conn.Exec("UPDATE workers SET heartbeat_at = now() - interval '1 hour' WHERE id = $1", w.ID)
+	test.AssertNotError(t, err, "")
+
+	deleted, err := workers.DeleteDead(time.Now().Add(-time.Minute))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, deleted, int64(0))
+
+	err = queued_jobs.Release(qj.ID)
+	test.AssertNotError(t, err, "")
+	deleted, err = workers.DeleteDead(time.Now().Add(-time.Minute))
+	test.AssertNotError(t, err, "")
+	test.AssertEquals(t, deleted, int64(1))
+}